@@ -0,0 +1,145 @@
+package samepic
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+// An AlphaMode determines how a samer treats transparent
+// pixels.
+type AlphaMode int
+
+const (
+	// IgnoreAlpha uses the (premultiplied) colors of the
+	// pixels as they are, so transparent pixels look
+	// black.
+	IgnoreAlpha AlphaMode = iota
+
+	// CompositeAlpha composites images onto a solid
+	// background color before comparing them.
+	CompositeAlpha
+
+	// MaskAlpha leaves transparent pixels out of the
+	// comparison, and weights partially transparent
+	// pixels by their opacity.
+	MaskAlpha
+
+	// CompareAlpha is like MaskAlpha, but it also compares
+	// the shapes of the images' alpha masks.
+	CompareAlpha
+)
+
+// ParseAlphaMode parses an alpha mode (ignore, composite,
+// mask, or compare).
+// The composite mode uses a white background by default,
+// but another background may be specified in hex, e.g.
+// "composite:000000".
+func ParseAlphaMode(name string) (AlphaMode, color.RGBA, error) {
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	switch {
+	case name == "ignore":
+		return IgnoreAlpha, white, nil
+	case name == "composite":
+		return CompositeAlpha, white, nil
+	case strings.HasPrefix(name, "composite:"):
+		background, err := parseHexColor(strings.TrimPrefix(name, "composite:"))
+		return CompositeAlpha, background, err
+	case name == "mask":
+		return MaskAlpha, white, nil
+	case name == "compare":
+		return CompareAlpha, white, nil
+	default:
+		return 0, white, errors.New("unknown alpha mode: " + name)
+	}
+}
+
+// masked checks if the mode leaves out transparent
+// pixels.
+func (a AlphaMode) masked() bool {
+	return a == MaskAlpha || a == CompareAlpha
+}
+
+// pixel converts a color according to the alpha mode,
+// returning an opaque color and the weight of the pixel.
+// The background is only used by CompositeAlpha, and its
+// alpha component is ignored.
+func (a AlphaMode) pixel(c color.Color, background color.RGBA) (color.Color, float64) {
+	switch a {
+	case IgnoreAlpha:
+		return c, 1
+	case CompositeAlpha:
+		r, g, b, alpha := c.RGBA()
+		composite := func(x uint32, bg uint8) uint16 {
+			return uint16(x + uint32(bg)*0x101*(0xffff-alpha)/0xffff)
+		}
+		return color.RGBA64{
+			R: composite(r, background.R),
+			G: composite(g, background.G),
+			B: composite(b, background.B),
+			A: 0xffff,
+		}, 1
+	case MaskAlpha, CompareAlpha:
+		straight := color.NRGBA64Model.Convert(c).(color.NRGBA64)
+		if straight.A == 0 {
+			return c, 0
+		}
+		return color.RGBA64{R: straight.R, G: straight.G, B: straight.B, A: 0xffff},
+			float64(straight.A) / 0xffff
+	default:
+		panic("unknown alpha mode")
+	}
+}
+
+// grayAlphaPixels is like grayPixels, but it returns the
+// brightness of the unpremultiplied colors along with the
+// opacity of each pixel.
+func grayAlphaPixels(img image.Image, width, height int) (brightness, alpha []float64) {
+	scaled := resize.Resize(uint(width), uint(height), img, resize.Bilinear)
+	brightness = make([]float64, 0, width*height)
+	alpha = make([]float64, 0, width*height)
+	for y := scaled.Bounds().Min.Y; y < scaled.Bounds().Max.Y; y++ {
+		for x := scaled.Bounds().Min.X; x < scaled.Bounds().Max.X; x++ {
+			r, g, b, a := scaled.At(x, y).RGBA()
+			var gray float64
+			if a > 0 {
+				gray = (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / float64(a)
+			}
+			brightness = append(brightness, gray)
+			alpha = append(alpha, float64(a)/0xffff)
+		}
+	}
+	return
+}
+
+// maskedHashMatchRatio compares hashes whose second half
+// indicates which pixels are opaque.
+// Only the bits which are opaque in both hashes are
+// compared, along with the mask bits themselves if
+// compareMasks is true.
+func maskedHashMatchRatio(h1, h2 []bool, compareMasks bool) float64 {
+	n := len(h1) / 2
+	var matches, total int
+	for i := 0; i < n; i++ {
+		opaque1, opaque2 := h1[n+i], h2[n+i]
+		if compareMasks {
+			total++
+			if opaque1 == opaque2 {
+				matches++
+			}
+		}
+		if opaque1 && opaque2 {
+			total++
+			if h1[i] == h2[i] {
+				matches++
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(matches) / float64(total)
+}
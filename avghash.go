@@ -3,8 +3,6 @@ package samepic
 import (
 	"image"
 	"image/color"
-
-	"github.com/nfnt/resize"
 )
 
 const (
@@ -23,6 +21,19 @@ type AverageHash struct {
 	// If this is 0, DefaultAverageHashScaleSize is used.
 	ScaleSize int
 
+	// AlphaMode determines how transparent pixels are
+	// handled.
+	// With MaskAlpha or CompareAlpha, the hash has a
+	// second half indicating which pixels are opaque.
+	// The default is IgnoreAlpha.
+	AlphaMode AlphaMode
+
+	// AlphaBackground is the background color for
+	// CompositeAlpha.
+	// Its alpha component is ignored, so the zero value
+	// is opaque black.
+	AlphaBackground color.RGBA
+
 	// Threshold is the minimum fraction of hash bits that
 	// must match for two images to be considered the same.
 	//
@@ -35,48 +46,30 @@ type AverageHash struct {
 func (a *AverageHash) Same(img1, img2 image.Image) bool {
 	hash1 := a.Hash(img1)
 	hash2 := a.Hash(img2)
-	return hashMatchRatio(hash1, hash2) >= a.threshold()
+	return a.matchRatio(hash1, hash2) >= a.threshold()
 }
 
 // SameBatch finds pairs of near duplicates.
 func (a *AverageHash) SameBatch(images <-chan *IDImage) <-chan *Pair {
-	res := make(chan *Pair, 1)
-	go func() {
-		defer close(res)
-		ids := []interface{}{}
-		hashes := [][]bool{}
-		for image := range images {
-			hash := a.Hash(image.Image)
-			for i, hash1 := range hashes {
-				if hashMatchRatio(hash, hash1) >= a.threshold() {
-					res <- &Pair{ids[i], image.ID}
-				}
-			}
-			ids = append(ids, image.ID)
-			hashes = append(hashes, hash)
-		}
-	}()
-	return res
+	return ratioHashSameBatch(images, a.Hash, a.matchRatio, a.threshold())
 }
 
 // Hash creates the perceptual hash of an image.
 func (a *AverageHash) Hash(img image.Image) []bool {
-	scaleSize := uint(a.ScaleSize)
+	scaleSize := a.ScaleSize
 	if scaleSize == 0 {
 		scaleSize = DefaultAverageHashScaleSize
 	}
-	scaled := resize.Resize(scaleSize, scaleSize, img, resize.Bilinear)
+	if a.AlphaMode.masked() {
+		return a.maskedHash(img, scaleSize)
+	} else if a.AlphaMode == CompositeAlpha {
+		img = (&FlattenAlpha{Background: a.AlphaBackground}).Manipulate(img)
+	}
+	brightnesses := grayPixels(img, scaleSize, scaleSize)
 
-	brightnesses := make([]float64, 0, scaleSize*scaleSize)
 	var sum float64
-	for y := scaled.Bounds().Min.Y; y < scaled.Bounds().Max.Y; y++ {
-		for x := scaled.Bounds().Min.X; x < scaled.Bounds().Max.X; x++ {
-			gray := color.GrayModel.Convert(scaled.At(x, y))
-			r, _, _, _ := gray.RGBA()
-			b := float64(r) / 0xffff
-			brightnesses = append(brightnesses, b)
-			sum += b
-		}
+	for _, b := range brightnesses {
+		sum += b
 	}
 	mean := sum / float64(len(brightnesses))
 	res := make([]bool, len(brightnesses))
@@ -86,6 +79,47 @@ func (a *AverageHash) Hash(img image.Image) []bool {
 	return res
 }
 
+// maskedHash computes a hash using only the opaque
+// pixels, followed by a mask of the opaque pixels.
+func (a *AverageHash) maskedHash(img image.Image, scaleSize int) []bool {
+	brightnesses, alphas := grayAlphaPixels(img, scaleSize, scaleSize)
+
+	var sum, count float64
+	for i, b := range brightnesses {
+		if alphas[i] >= 0.5 {
+			sum += b
+			count++
+		}
+	}
+	mean := sum / count
+	res := make([]bool, len(brightnesses)*2)
+	for i, x := range brightnesses {
+		opaque := alphas[i] >= 0.5
+		res[i] = opaque && x > mean
+		res[len(brightnesses)+i] = opaque
+	}
+	return res
+}
+
+func (a *AverageHash) matchRatio(h1, h2 []bool) float64 {
+	if a.AlphaMode.masked() {
+		return maskedHashMatchRatio(h1, h2, a.AlphaMode == CompareAlpha)
+	}
+	return hashMatchRatio(h1, h2)
+}
+
+// transformHash rearranges the bits of a hash to get the
+// hash of a transformed image.
+func (a *AverageHash) transformHash(hash []bool, d Dihedral) []bool {
+	if a.AlphaMode.masked() {
+		half := len(hash) / 2
+		size := squareSide(half)
+		return append(transformGridBits(hash[:half], size, d),
+			transformGridBits(hash[half:], size, d)...)
+	}
+	return transformGridBits(hash, squareSide(len(hash)), d)
+}
+
 func (a *AverageHash) threshold() float64 {
 	if a.Threshold == 0 {
 		return DefaultAverageHashThreshold
@@ -103,3 +137,34 @@ func hashMatchRatio(h1, h2 []bool) float64 {
 	}
 	return float64(matchCount) / float64(len(h1))
 }
+
+// hashSameBatch implements SameBatch for samers which
+// compare binary hashes by their fraction of matching
+// bits.
+func hashSameBatch(images <-chan *IDImage, hashFunc func(image.Image) []bool,
+	threshold float64) <-chan *Pair {
+	return ratioHashSameBatch(images, hashFunc, hashMatchRatio, threshold)
+}
+
+// ratioHashSameBatch is like hashSameBatch, but with a
+// custom measure of the similarity between hashes.
+func ratioHashSameBatch(images <-chan *IDImage, hashFunc func(image.Image) []bool,
+	ratioFunc func(h1, h2 []bool) float64, threshold float64) <-chan *Pair {
+	res := make(chan *Pair, 1)
+	go func() {
+		defer close(res)
+		ids := []interface{}{}
+		hashes := [][]bool{}
+		for image := range images {
+			hash := hashFunc(image.Image)
+			for i, hash1 := range hashes {
+				if ratioFunc(hash, hash1) >= threshold {
+					res <- &Pair{ids[i], image.ID}
+				}
+			}
+			ids = append(ids, image.ID)
+			hashes = append(hashes, hash)
+		}
+	}()
+	return res
+}
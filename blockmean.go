@@ -0,0 +1,114 @@
+package samepic
+
+import "image"
+
+const (
+	DefaultBlockMeanHashScaleSize = 64
+	DefaultBlockMeanHashGridSize  = 16
+	DefaultBlockMeanHashThreshold = 0.85
+)
+
+// BlockMeanHash compares two images by dividing them
+// into a grid of blocks and hashing whether each block's
+// mean brightness is above the median of all the block
+// means.
+type BlockMeanHash struct {
+	// ScaleSize is the size to which images are scaled
+	// before they are divided into blocks.
+	//
+	// If this is 0, DefaultBlockMeanHashScaleSize is used.
+	ScaleSize int
+
+	// GridSize is the number of blocks along each axis.
+	//
+	// If this is 0, DefaultBlockMeanHashGridSize is used.
+	GridSize int
+
+	// Overlap, if true, adds blocks which straddle the
+	// boundaries between neighboring blocks, so that
+	// blocks are spaced half a block apart.
+	// This gives (2*GridSize-1)^2 blocks instead of
+	// GridSize^2.
+	Overlap bool
+
+	// Threshold is the minimum fraction of hash bits that
+	// must match for two images to be considered the same.
+	//
+	// If this is 0, DefaultBlockMeanHashThreshold is used.
+	Threshold float64
+}
+
+// Same computes the hashes of two images and uses the
+// results to determine if the images are the same.
+func (b *BlockMeanHash) Same(img1, img2 image.Image) bool {
+	hash1 := b.Hash(img1)
+	hash2 := b.Hash(img2)
+	return hashMatchRatio(hash1, hash2) >= b.threshold()
+}
+
+// SameBatch finds pairs of near duplicates.
+func (b *BlockMeanHash) SameBatch(images <-chan *IDImage) <-chan *Pair {
+	return hashSameBatch(images, b.Hash, b.threshold())
+}
+
+// Hash creates the perceptual hash of an image.
+// The bits are in row-major order of the blocks.
+func (b *BlockMeanHash) Hash(img image.Image) []bool {
+	scaleSize := b.ScaleSize
+	if scaleSize == 0 {
+		scaleSize = DefaultBlockMeanHashScaleSize
+	}
+	gridSize := b.GridSize
+	if gridSize == 0 {
+		gridSize = DefaultBlockMeanHashGridSize
+	}
+	if gridSize > scaleSize {
+		gridSize = scaleSize
+	}
+	pixels := grayPixels(img, scaleSize, scaleSize)
+
+	blockSize := scaleSize / gridSize
+	step := blockSize
+	blockCount := gridSize
+	if b.Overlap && blockSize > 1 {
+		step = blockSize / 2
+		blockCount = 2*gridSize - 1
+	}
+
+	means := make([]float64, 0, blockCount*blockCount)
+	for blockY := 0; blockY < blockCount; blockY++ {
+		for blockX := 0; blockX < blockCount; blockX++ {
+			var sum float64
+			for y := blockY * step; y < blockY*step+blockSize; y++ {
+				for x := blockX * step; x < blockX*step+blockSize; x++ {
+					sum += pixels[y*scaleSize+x]
+				}
+			}
+			means = append(means, sum/float64(blockSize*blockSize))
+		}
+	}
+
+	median := medianValue(means)
+	res := make([]bool, len(means))
+	for i, x := range means {
+		res[i] = x > median
+	}
+	return res
+}
+
+// transformHash rearranges the bits of a hash to get the
+// hash of a transformed image.
+func (b *BlockMeanHash) transformHash(hash []bool, d Dihedral) []bool {
+	return transformGridBits(hash, squareSide(len(hash)), d)
+}
+
+func (b *BlockMeanHash) matchRatio(h1, h2 []bool) float64 {
+	return hashMatchRatio(h1, h2)
+}
+
+func (b *BlockMeanHash) threshold() float64 {
+	if b.Threshold == 0 {
+		return DefaultBlockMeanHashThreshold
+	}
+	return b.Threshold
+}
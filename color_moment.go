@@ -0,0 +1,216 @@
+package samepic
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/nfnt/resize"
+)
+
+const (
+	DefaultColorMomentHashScaleSize  = 64
+	DefaultColorMomentHashBlurRadius = 2
+	DefaultColorMomentHashRingCount  = 3
+	DefaultColorMomentHashCutoff     = 0.04
+)
+
+// ColorMomentHash compares images using a compact vector
+// of color moments: the mean, standard deviation, and
+// skewness of each color component.
+//
+// Moments are computed over concentric rings around the
+// center of a blurred copy of the image, so the hash is
+// unaffected by rotating the image about its center and
+// is robust to mild color edits.
+type ColorMomentHash struct {
+	// ScaleSize is the size to which images are scaled
+	// before computing moments.
+	//
+	// If this is 0, DefaultColorMomentHashScaleSize is
+	// used.
+	ScaleSize int
+
+	// BlurRadius is the radius of the box blur applied
+	// to the scaled image.
+	//
+	// If this is 0, DefaultColorMomentHashBlurRadius is
+	// used.
+	BlurRadius int
+
+	// RingCount is the number of concentric rings in
+	// which moments are computed.
+	// Pixels outside of the image's inscribed circle are
+	// ignored.
+	//
+	// If this is 0, DefaultColorMomentHashRingCount is
+	// used.
+	RingCount int
+
+	// ColorSpaces stores the color spaces in which the
+	// moments are computed.
+	// If this is nil, RGBSpace and LabSpace are used.
+	ColorSpaces []ColorSpace
+
+	// Cutoff is the maximum root-mean-square difference
+	// between two hashes for the images to be considered
+	// the same.
+	//
+	// If this is 0, DefaultColorMomentHashCutoff is used.
+	Cutoff float64
+}
+
+// Same compares the hashes of two images.
+func (c *ColorMomentHash) Same(img1, img2 image.Image) bool {
+	return rmsDifference(c.Hash(img1), c.Hash(img2)) <= c.cutoff()
+}
+
+// SameBatch finds pairs of near duplicates.
+func (c *ColorMomentHash) SameBatch(images <-chan *IDImage) <-chan *Pair {
+	res := make(chan *Pair, 1)
+	go func() {
+		defer close(res)
+		ids := []interface{}{}
+		hashes := [][]float64{}
+		for image := range images {
+			hash := c.Hash(image.Image)
+			for i, hash1 := range hashes {
+				if rmsDifference(hash, hash1) <= c.cutoff() {
+					res <- &Pair{ids[i], image.ID}
+				}
+			}
+			ids = append(ids, image.ID)
+			hashes = append(hashes, hash)
+		}
+	}()
+	return res
+}
+
+// Hash computes the color moment vector for an image.
+//
+// For each color space, ring, and component (in that
+// order of nesting), the vector contains the mean,
+// standard deviation, and cube root of the third central
+// moment.
+func (c *ColorMomentHash) Hash(img image.Image) []float64 {
+	scaleSize := c.ScaleSize
+	if scaleSize == 0 {
+		scaleSize = DefaultColorMomentHashScaleSize
+	}
+	blurRadius := c.BlurRadius
+	if blurRadius == 0 {
+		blurRadius = DefaultColorMomentHashBlurRadius
+	}
+	ringCount := c.RingCount
+	if ringCount == 0 {
+		ringCount = DefaultColorMomentHashRingCount
+	}
+	spaces := c.ColorSpaces
+	if spaces == nil {
+		spaces = []ColorSpace{RGBSpace, LabSpace}
+	}
+
+	planes := blurredPlanes(img, scaleSize, blurRadius)
+
+	center := float64(scaleSize) / 2
+	rings := make([]int, scaleSize*scaleSize)
+	for y := 0; y < scaleSize; y++ {
+		for x := 0; x < scaleSize; x++ {
+			dist := math.Hypot(float64(x)+0.5-center, float64(y)+0.5-center)
+			rings[y*scaleSize+x] = int(dist / center * float64(ringCount))
+		}
+	}
+
+	var res []float64
+	for _, space := range spaces {
+		components := make([][3]float64, len(rings))
+		for i := range components {
+			components[i] = colorComponents(color.RGBA64{
+				R: uint16(planes[0][i]*0xffff + 0.5),
+				G: uint16(planes[1][i]*0xffff + 0.5),
+				B: uint16(planes[2][i]*0xffff + 0.5),
+				A: 0xffff,
+			}, space)
+		}
+		for ring := 0; ring < ringCount; ring++ {
+			for channel := 0; channel < 3; channel++ {
+				var values []float64
+				for i, r := range rings {
+					if r == ring {
+						values = append(values, components[i][channel])
+					}
+				}
+				res = append(res, colorMoments(values)...)
+			}
+		}
+	}
+	return res
+}
+
+func (c *ColorMomentHash) cutoff() float64 {
+	if c.Cutoff == 0 {
+		return DefaultColorMomentHashCutoff
+	}
+	return c.Cutoff
+}
+
+// blurredPlanes scales an image and box blurs its R, G,
+// and B planes, which are returned in row-major order
+// with values between 0 and 1.
+func blurredPlanes(img image.Image, size, radius int) [3][]float64 {
+	scaled := resize.Resize(uint(size), uint(size), img, resize.Bilinear)
+	bounds := scaled.Bounds()
+	var planes [3][]float64
+	for i := range planes {
+		planes[i] = make([]float64, 0, size*size)
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := scaled.At(x, y).RGBA()
+			planes[0] = append(planes[0], float64(r)/0xffff)
+			planes[1] = append(planes[1], float64(g)/0xffff)
+			planes[2] = append(planes[2], float64(b)/0xffff)
+		}
+	}
+
+	var res [3][]float64
+	for i, plane := range planes {
+		matrix := &grayMatrix{Width: size, Height: size, Pix: plane}
+		res[i] = matrix.BoxBlur(radius).Pix
+	}
+	return res
+}
+
+// colorMoments computes the mean, standard deviation,
+// and cube root of the third central moment of a list
+// of values.
+func colorMoments(values []float64) []float64 {
+	if len(values) == 0 {
+		return []float64{0, 0, 0}
+	}
+	var mean float64
+	for _, x := range values {
+		mean += x
+	}
+	mean /= float64(len(values))
+	var variance, third float64
+	for _, x := range values {
+		diff := x - mean
+		variance += diff * diff
+		third += diff * diff * diff
+	}
+	variance /= float64(len(values))
+	third /= float64(len(values))
+	return []float64{mean, math.Sqrt(variance), math.Cbrt(third)}
+}
+
+// rmsDifference computes the root-mean-square of the
+// differences between two vectors.
+func rmsDifference(v1, v2 []float64) float64 {
+	var sum float64
+	for i, x := range v1 {
+		diff := x - v2[i]
+		sum += diff * diff
+	}
+	return math.Sqrt(sum / float64(len(v1)))
+}
@@ -0,0 +1,113 @@
+package samepic
+
+import (
+	"image/color"
+	"math"
+)
+
+// A ColorSpace is a representation of colors as three
+// components.
+type ColorSpace int
+
+const (
+	RGBSpace ColorSpace = iota
+	HSVSpace
+	HSLSpace
+	LabSpace
+)
+
+// colorComponents converts a color to the given color
+// space, scaling each component to lie between 0 and 1.
+//
+// For HSVSpace and HSLSpace, the hue is the first
+// component.
+// For LabSpace, the components are L, a, and b, where a
+// and b are shifted so that 0.5 is neutral.
+func colorComponents(c color.Color, space ColorSpace) [3]float64 {
+	r32, g32, b32, _ := c.RGBA()
+	r := float64(r32) / 0xffff
+	g := float64(g32) / 0xffff
+	b := float64(b32) / 0xffff
+
+	switch space {
+	case RGBSpace:
+		return [3]float64{r, g, b}
+	case HSVSpace:
+		max := math.Max(r, math.Max(g, b))
+		min := math.Min(r, math.Min(g, b))
+		var saturation float64
+		if max > 0 {
+			saturation = (max - min) / max
+		}
+		return [3]float64{hue(r, g, b, max, min), saturation, max}
+	case HSLSpace:
+		max := math.Max(r, math.Max(g, b))
+		min := math.Min(r, math.Min(g, b))
+		lightness := (max + min) / 2
+		var saturation float64
+		if max > min {
+			saturation = (max - min) / (1 - math.Abs(2*lightness-1))
+		}
+		return [3]float64{hue(r, g, b, max, min), math.Min(1, saturation), lightness}
+	case LabSpace:
+		return labComponents(r, g, b)
+	default:
+		panic("unknown color space")
+	}
+}
+
+// hue computes the hue of an RGB color as a fraction of
+// a full turn.
+func hue(r, g, b, max, min float64) float64 {
+	chroma := max - min
+	if chroma == 0 {
+		return 0
+	}
+	var h float64
+	switch max {
+	case r:
+		h = math.Mod((g-b)/chroma+6, 6)
+	case g:
+		h = (b-r)/chroma + 2
+	default:
+		h = (r-g)/chroma + 4
+	}
+	return h / 6
+}
+
+// labComponents converts an sRGB color to CIE Lab using
+// the D65 white point.
+func labComponents(r, g, b float64) [3]float64 {
+	linear := func(c float64) float64 {
+		if c <= 0.04045 {
+			return c / 12.92
+		}
+		return math.Pow((c+0.055)/1.055, 2.4)
+	}
+	r, g, b = linear(r), linear(g), linear(b)
+
+	x := (0.4124*r + 0.3576*g + 0.1805*b) / 0.95047
+	y := 0.2126*r + 0.7152*g + 0.0722*b
+	z := (0.0193*r + 0.1192*g + 0.9505*b) / 1.08883
+
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+
+	l := 116*fy - 16
+	a := 500 * (fx - fy)
+	bComp := 200 * (fy - fz)
+	return [3]float64{
+		clampUnit(l / 100),
+		clampUnit((a + 128) / 255),
+		clampUnit((bComp + 128) / 255),
+	}
+}
+
+func clampUnit(x float64) float64 {
+	return math.Max(0, math.Min(1, x))
+}
@@ -2,6 +2,8 @@ package samepic
 
 import (
 	"image"
+	"image/color"
+	"math"
 
 	"github.com/unixpickle/num-analysis/linalg"
 )
@@ -9,30 +11,107 @@ import (
 const (
 	DefaultColorProfBinCount  = 8
 	DefaultColorProfThreshold = 0.97
+
+	DefaultColorProfIntersectionThreshold  = 0.85
+	DefaultColorProfChiSquareThreshold     = 0.95
+	DefaultColorProfBhattacharyyaThreshold = 0.85
+	DefaultColorProfEMDThreshold           = 0.97
 )
 
 // ColorProf compares two images by generating color
 // histograms for each image and comparing the resulting
-// histograms using correlation.
+// histograms using a HistogramMetric.
 type ColorProf struct {
 	// BinCount is the number of bins in each of the
-	// three histograms (R, G, and B).
+	// three histograms (e.g. R, G, and B).
+	// For joint histograms, it is the number of bins
+	// along each of the three axes.
 	//
 	// If this is 0, DefaultColorProfBinCount is used.
 	BinCount int
 
-	// Threshold is the minimum correlation between two
-	// color histograms for them to be considered the
-	// same.
+	// ColorSpace is the color space in which colors are
+	// binned.
+	// Perceptual spaces like HSVSpace and LabSpace make
+	// it possible to isolate (and down-weight) the
+	// components affected by saturation and white
+	// balance edits.
+	// The default is RGBSpace.
+	ColorSpace ColorSpace
+
+	// Joint, if true, uses a single three-dimensional
+	// histogram with BinCount^3 bins instead of three
+	// separate histograms.
+	// A joint histogram captures which component values
+	// occur together, at the cost of being sparser.
+	Joint bool
+
+	// Weights, if non-nil, stores one weight for each of
+	// the three histograms, scaling that histogram's
+	// contribution to the comparison.
+	// For example, {1, 0.25, 1} down-weights saturation
+	// in HSVSpace.
+	// Weights are not used for joint histograms.
+	// With CompareAlpha, the alpha histogram has a weight
+	// of 1 unless a fourth weight is given.
+	Weights []float64
+
+	// Metric is the measure of similarity between
+	// histograms.
+	// The default is CorrelationMetric.
+	Metric HistogramMetric
+
+	// GridRows and GridCols, if greater than 1, divide
+	// each image into a grid of cells and compare the
+	// histograms of corresponding cells, averaging the
+	// results.
+	// This preserves the spatial layout of colors, so
+	// different images with similar palettes are less
+	// likely to match.
+	// Spatial profiles are stricter than global ones,
+	// so they typically call for a lower Threshold.
+	GridRows int
+	GridCols int
+
+	// GridShift is the maximum number of cells by which
+	// one grid may be shifted relative to the other
+	// (along each axis) when comparing spatial profiles.
+	// Shifted grids only compare the cells which overlap,
+	// and the best shift is used, providing some
+	// tolerance to cropping.
+	// Shifts which overlap fewer than half of the cells
+	// are not considered.
+	GridShift int
+
+	// AlphaMode determines how transparent pixels are
+	// handled.
+	// With MaskAlpha, pixels count towards the histograms
+	// in proportion to their opacity.
+	// With CompareAlpha, a histogram of the alpha channel
+	// is compared as well.
+	// The default is IgnoreAlpha.
+	AlphaMode AlphaMode
+
+	// AlphaBackground is the background color for
+	// CompositeAlpha.
+	// Its alpha component is ignored, so the zero value
+	// is opaque black.
+	AlphaBackground color.RGBA
+
+	// Threshold is the minimum similarity (as measured
+	// by Metric) between two color histograms for them
+	// to be considered the same.
 	//
-	// If this is 0, DefaultColorProfThreshold is used.
+	// If this is 0, a default is chosen based on the
+	// metric, e.g. DefaultColorProfThreshold for
+	// CorrelationMetric.
 	Threshold float64
 }
 
 // Same decides if two images are the same by comparing
 // their color histograms.
 func (c *ColorProf) Same(img1, img2 image.Image) bool {
-	return c.match(c.Histograms(img1), c.Histograms(img2))
+	return c.match(c.profile(img1), c.profile(img2))
 }
 
 // SameBatch finds pairs of near duplicates.
@@ -41,9 +120,9 @@ func (c *ColorProf) SameBatch(images <-chan *IDImage) <-chan *Pair {
 	go func() {
 		defer close(res)
 		ids := []interface{}{}
-		hists := [][3]linalg.Vector{}
+		hists := []*colorGrid{}
 		for image := range images {
-			hist := c.Histograms(image.Image)
+			hist := c.profile(image.Image)
 			for i, hist1 := range hists {
 				if c.match(hist, hist1) {
 					res <- &Pair{ids[i], image.ID}
@@ -56,46 +135,205 @@ func (c *ColorProf) SameBatch(images <-chan *IDImage) <-chan *Pair {
 	return res
 }
 
-// Histograms generates the R, G, and B histograms for
-// the given image.
+// Histograms generates a histogram for each of the three
+// components (e.g. R, G, and B) of the given image.
 func (c *ColorProf) Histograms(img image.Image) [3]linalg.Vector {
+	return c.regionHistograms(img, img.Bounds())
+}
+
+// JointHistogram generates a three-dimensional histogram
+// of the given image.
+// Bin (i, j, k) is stored at index i*BinCount^2 +
+// j*BinCount + k.
+func (c *ColorProf) JointHistogram(img image.Image) linalg.Vector {
+	return c.regionJointHistogram(img, img.Bounds())
+}
+
+func (c *ColorProf) regionHistograms(img image.Image, rect image.Rectangle) [3]linalg.Vector {
 	var res [3]linalg.Vector
 	for i := 0; i < 3; i++ {
-		binCount := c.BinCount
-		if binCount == 0 {
-			binCount = DefaultColorProfBinCount
+		res[i] = make(linalg.Vector, c.binCount())
+	}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			pixel, weight := c.AlphaMode.pixel(img.At(x, y), c.AlphaBackground)
+			if weight == 0 {
+				continue
+			}
+			components := colorComponents(pixel, c.ColorSpace)
+			for i, component := range components {
+				res[i][c.binIdx(component)] += weight
+			}
+		}
+	}
+	return res
+}
+
+func (c *ColorProf) regionJointHistogram(img image.Image, rect image.Rectangle) linalg.Vector {
+	binCount := c.binCount()
+	res := make(linalg.Vector, binCount*binCount*binCount)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			pixel, weight := c.AlphaMode.pixel(img.At(x, y), c.AlphaBackground)
+			if weight == 0 {
+				continue
+			}
+			components := colorComponents(pixel, c.ColorSpace)
+			idx := 0
+			for _, component := range components {
+				idx = idx*binCount + c.binIdx(component)
+			}
+			res[idx] += weight
 		}
-		res[i] = make(linalg.Vector, binCount)
-	}
-	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
-		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
-			r, g, b, _ := img.At(x, y).RGBA()
-			res[0][c.binIdx(r)]++
-			res[1][c.binIdx(g)]++
-			res[2][c.binIdx(b)]++
+	}
+	return res
+}
+
+// regionAlphaHistogram generates a histogram of the
+// opacity of the pixels in a region.
+func (c *ColorProf) regionAlphaHistogram(img image.Image, rect image.Rectangle) linalg.Vector {
+	res := make(linalg.Vector, c.binCount())
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			_, _, _, alpha := img.At(x, y).RGBA()
+			res[c.binIdx(float64(alpha)/0xffff)]++
 		}
 	}
 	return res
 }
 
-func (c *ColorProf) match(hist1, hist2 [3]linalg.Vector) bool {
-	joinedHist1 := joinVecs(hist1[:])
-	joinedHist2 := joinVecs(hist2[:])
+// A colorGrid stores the histograms for each cell of a
+// spatial grid, in row-major order.
+// Without a grid, there is a single cell.
+type colorGrid struct {
+	Rows  int
+	Cols  int
+	Cells [][]linalg.Vector
+}
 
-	correlation := joinedHist1.Dot(joinedHist2) / (joinedHist1.Mag() * joinedHist2.Mag())
+// profile generates the histograms which are compared
+// for the given image.
+func (c *ColorProf) profile(img image.Image) *colorGrid {
+	rows, cols := c.GridRows, c.GridCols
+	if rows < 1 {
+		rows = 1
+	}
+	if cols < 1 {
+		cols = 1
+	}
+	bounds := img.Bounds()
+	res := &colorGrid{Rows: rows, Cols: cols}
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			cell := image.Rect(
+				bounds.Min.X+col*bounds.Dx()/cols,
+				bounds.Min.Y+row*bounds.Dy()/rows,
+				bounds.Min.X+(col+1)*bounds.Dx()/cols,
+				bounds.Min.Y+(row+1)*bounds.Dy()/rows,
+			)
+			var hists []linalg.Vector
+			if c.Joint {
+				hists = []linalg.Vector{c.regionJointHistogram(img, cell)}
+			} else {
+				components := c.regionHistograms(img, cell)
+				hists = components[:]
+			}
+			if c.AlphaMode == CompareAlpha {
+				hists = append(hists, c.regionAlphaHistogram(img, cell))
+			}
+			res.Cells = append(res.Cells, hists)
+		}
+	}
+	return res
+}
+
+func (c *ColorProf) match(grid1, grid2 *colorGrid) bool {
+	similarity := c.gridSimilarity(grid1, grid2)
 	if c.Threshold == 0 {
-		return correlation >= DefaultColorProfThreshold
+		return similarity >= c.Metric.defaultThreshold()
 	} else {
-		return correlation >= c.Threshold
+		return similarity >= c.Threshold
+	}
+}
+
+// gridSimilarity averages the similarities of the
+// corresponding cells of two grids, using the best
+// allowed shift between the grids.
+func (c *ColorProf) gridSimilarity(grid1, grid2 *colorGrid) float64 {
+	// A shift that overlaps only a few cells could match
+	// unrelated images by chance.
+	minCount := float64(grid1.Rows*grid1.Cols+1) / 2
+	best := math.Inf(-1)
+	for shiftY := -c.GridShift; shiftY <= c.GridShift; shiftY++ {
+		for shiftX := -c.GridShift; shiftX <= c.GridShift; shiftX++ {
+			var sum, count float64
+			for row := 0; row < grid1.Rows; row++ {
+				row2 := row + shiftY
+				if row2 < 0 || row2 >= grid2.Rows {
+					continue
+				}
+				for col := 0; col < grid1.Cols; col++ {
+					col2 := col + shiftX
+					if col2 < 0 || col2 >= grid2.Cols {
+						continue
+					}
+					sum += c.similarity(grid1.Cells[row*grid1.Cols+col],
+						grid2.Cells[row2*grid2.Cols+col2])
+					count++
+				}
+			}
+			if count >= minCount {
+				best = math.Max(best, sum/count)
+			}
+		}
+	}
+	return best
+}
+
+// similarity compares the histograms for a single cell.
+func (c *ColorProf) similarity(hists1, hists2 []linalg.Vector) float64 {
+	weights := c.Weights
+	if c.AlphaMode == CompareAlpha && len(weights)+1 == len(hists1) {
+		weights = append(append([]float64{}, weights...), 1)
+	}
+	if weights == nil || len(hists1) != len(weights) {
+		weights = make([]float64, len(hists1))
+		for i := range weights {
+			weights[i] = 1
+		}
 	}
+
+	if c.Metric == CorrelationMetric {
+		// Correlation is computed on the joined histograms,
+		// so the weights scale each histogram directly.
+		var weighted1, weighted2 []linalg.Vector
+		for i, w := range weights {
+			weighted1 = append(weighted1, hists1[i].Copy().Scale(w))
+			weighted2 = append(weighted2, hists2[i].Copy().Scale(w))
+		}
+		return c.Metric.similarity(joinVecs(weighted1), joinVecs(weighted2), c.binCount())
+	}
+
+	var sum, weightSum float64
+	for i, w := range weights {
+		sum += w * c.Metric.similarity(normalizeHistogram(hists1[i]),
+			normalizeHistogram(hists2[i]), c.binCount())
+		weightSum += w
+	}
+	return sum / weightSum
 }
 
-func (c *ColorProf) binIdx(component uint32) int {
-	binCount := c.BinCount
-	if binCount == 0 {
-		binCount = DefaultColorProfBinCount
+func (c *ColorProf) binCount() int {
+	if c.BinCount == 0 {
+		return DefaultColorProfBinCount
 	}
-	idx := int(float64(binCount) * float64(component) / 0xffff)
+	return c.BinCount
+}
+
+// binIdx finds the bin for a component between 0 and 1.
+func (c *ColorProf) binIdx(component float64) int {
+	binCount := c.binCount()
+	idx := int(float64(binCount) * component)
 	if idx >= binCount {
 		idx = binCount - 1
 	}
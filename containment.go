@@ -0,0 +1,340 @@
+package samepic
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	DefaultContainmentSearchSize = 128
+	DefaultContainmentMinScale   = 0.1
+	DefaultContainmentScaleStep  = 0.95
+	DefaultContainmentThreshold  = 0.9
+)
+
+const (
+	containmentMinTemplateSize  = 24
+	containmentFullTemplateSize = 48
+	containmentMinVariance      = 1e-4
+	containmentMaxOverhang      = 0.1
+)
+
+// Containment detects when one image appears within
+// another, such as a photo inside of a screenshot or a
+// crop pasted into a collage.
+//
+// Keypoint matching is tried first, since it tolerates
+// scaling, rotation, and perspective changes.
+// If it fails, a multi-scale template search compares the
+// contained image to every region of the containing
+// image using normalized cross-correlation.
+type Containment struct {
+	// Keypoints is used to match local features between
+	// the images.
+	// If this is nil, a KeypointSamer with default
+	// parameters is used.
+	Keypoints *KeypointSamer
+
+	// SearchSize is the length of the long edge to which
+	// the containing image is scaled for the template
+	// search.
+	//
+	// If this is 0, DefaultContainmentSearchSize is used.
+	SearchSize int
+
+	// MinScale is the smallest size of the contained
+	// image, relative to the largest size at which it
+	// fits in the containing image, for the template
+	// search.
+	// Matches which would shrink the contained image to
+	// less than MinScale of its native resolution are
+	// rejected by both searches.
+	//
+	// If this is 0, DefaultContainmentMinScale is used.
+	MinScale float64
+
+	// ScaleStep is the ratio between consecutive template
+	// sizes in the template search.
+	//
+	// If this is 0, DefaultContainmentScaleStep is used.
+	ScaleStep float64
+
+	// Threshold is the minimum normalized cross-correlation
+	// for the template search to find a match.
+	// Small templates correlate well by chance, so they
+	// must exceed a proportionally higher threshold.
+	//
+	// If this is 0, DefaultContainmentThreshold is used.
+	Threshold float64
+}
+
+// Contains checks if img2 appears within img1.
+// If it does, the bounding rectangle of img2 in img1's
+// coordinates is returned as well.
+func (c *Containment) Contains(img1, img2 image.Image) (bool, image.Rectangle) {
+	if rect, ok := c.keypointContains(img1, img2); ok {
+		return true, rect
+	}
+	if rect, ok := c.templateContains(img1, img2); ok {
+		return true, rect
+	}
+	return false, image.Rectangle{}
+}
+
+// keypointContains finds a homography from img2 to img1
+// and maps the corners of img2 through it.
+func (c *Containment) keypointContains(img1, img2 image.Image) (image.Rectangle, bool) {
+	keypoints := c.Keypoints
+	if keypoints == nil {
+		keypoints = &KeypointSamer{}
+	}
+	h, inliers := keypoints.match(keypoints.features(img2), keypoints.features(img1))
+	if inliers < keypoints.minInliers() {
+		return image.Rectangle{}, false
+	}
+
+	width, height := float64(img2.Bounds().Dx()), float64(img2.Bounds().Dy())
+	var corners [4][2]float64
+	for i, corner := range [4][2]float64{{0, 0}, {width, 0}, {width, height}, {0, height}} {
+		x, y := h.Apply(corner[0], corner[1])
+		if math.IsNaN(x) || math.IsNaN(y) || math.IsInf(x, 0) || math.IsInf(y, 0) {
+			return image.Rectangle{}, false
+		}
+		corners[i] = [2]float64{x, y}
+	}
+	if !isConvexQuad(corners) {
+		return image.Rectangle{}, false
+	}
+
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, corner := range corners {
+		minX, maxX = math.Min(minX, corner[0]), math.Max(maxX, corner[0])
+		minY, maxY = math.Min(minY, corner[1]), math.Max(maxY, corner[1])
+	}
+	_, minScale, _, _ := c.parameters()
+	if (maxX-minX)/width < minScale && (maxY-minY)/height < minScale {
+		return image.Rectangle{}, false
+	}
+
+	// A little of img2 may extend past the edges of img1
+	// due to estimation error, but not much more.
+	bounds := img1.Bounds()
+	overhangX := containmentMaxOverhang * float64(bounds.Dx())
+	overhangY := containmentMaxOverhang * float64(bounds.Dy())
+	if minX < -overhangX || minY < -overhangY ||
+		maxX > float64(bounds.Dx())+overhangX || maxY > float64(bounds.Dy())+overhangY {
+		return image.Rectangle{}, false
+	}
+
+	rect := image.Rect(
+		int(math.Floor(minX)), int(math.Floor(minY)),
+		int(math.Ceil(maxX)), int(math.Ceil(maxY)),
+	).Add(bounds.Min).Intersect(bounds)
+	if rect.Empty() {
+		return image.Rectangle{}, false
+	}
+	return rect, true
+}
+
+// templateContains searches for the scale and offset of
+// img2 which best correlates with a region of img1.
+func (c *Containment) templateContains(img1, img2 image.Image) (image.Rectangle, bool) {
+	searchSize, minScale, scaleStep, threshold := c.parameters()
+
+	bounds1, bounds2 := img1.Bounds(), img2.Bounds()
+	if bounds1.Empty() || bounds2.Empty() {
+		return image.Rectangle{}, false
+	}
+	searchScale := float64(searchSize) / float64(maxInt(bounds1.Dx(), bounds1.Dy()))
+	width := maxInt(1, int(float64(bounds1.Dx())*searchScale+0.5))
+	height := maxInt(1, int(float64(bounds1.Dy())*searchScale+0.5))
+	pixels := newGrayMatrix(img1, width, height)
+	sums := newIntegralImage(pixels)
+
+	fit := math.Min(float64(width)/float64(bounds2.Dx()), float64(height)/float64(bounds2.Dy()))
+
+	bestCorrelation := math.Inf(-1)
+	var bestRect image.Rectangle
+	found := false
+	for scale := 1.0; scale >= minScale; scale *= scaleStep {
+		templateWidth := int(float64(bounds2.Dx())*fit*scale + 0.5)
+		templateHeight := int(float64(bounds2.Dy())*fit*scale + 0.5)
+		shortSide := minInt(templateWidth, templateHeight)
+		if shortSide < containmentMinTemplateSize {
+			break
+		}
+		nativeScale := float64(templateWidth) / searchScale / float64(bounds2.Dx())
+		if nativeScale < minScale {
+			break
+		}
+		template := newGrayMatrix(img2, templateWidth, templateHeight)
+		correlation, x, y := templateSearch(pixels, sums, template)
+		if correlation >= templateThreshold(threshold, shortSide) && correlation > bestCorrelation {
+			bestCorrelation = correlation
+			bestRect = image.Rect(x, y, x+templateWidth, y+templateHeight)
+			found = true
+		}
+	}
+	if !found {
+		return image.Rectangle{}, false
+	}
+
+	rect := image.Rect(
+		int(float64(bestRect.Min.X)/searchScale+0.5),
+		int(float64(bestRect.Min.Y)/searchScale+0.5),
+		int(float64(bestRect.Max.X)/searchScale+0.5),
+		int(float64(bestRect.Max.Y)/searchScale+0.5),
+	).Add(bounds1.Min).Intersect(bounds1)
+	return rect, true
+}
+
+// templateThreshold raises the correlation threshold for
+// templates smaller than containmentFullTemplateSize,
+// reaching 1 for an empty template.
+func templateThreshold(threshold float64, shortSide int) float64 {
+	fraction := math.Min(1, float64(shortSide)/containmentFullTemplateSize)
+	return 1 - (1-threshold)*fraction
+}
+
+func (c *Containment) parameters() (searchSize int, minScale, scaleStep, threshold float64) {
+	searchSize, minScale, scaleStep, threshold = c.SearchSize, c.MinScale, c.ScaleStep, c.Threshold
+	if searchSize == 0 {
+		searchSize = DefaultContainmentSearchSize
+	}
+	if minScale == 0 {
+		minScale = DefaultContainmentMinScale
+	}
+	if scaleStep == 0 {
+		scaleStep = DefaultContainmentScaleStep
+	}
+	if threshold == 0 {
+		threshold = DefaultContainmentThreshold
+	}
+	return
+}
+
+// templateSearch finds the offset of a template in an
+// image with the greatest normalized cross-correlation.
+//
+// To save time, offsets are first searched on a grid
+// with a spacing of two pixels, and then the best offset
+// is refined.
+func templateSearch(pixels *grayMatrix, sums *integralImage,
+	template *grayMatrix) (correlation float64, bestX, bestY int) {
+	var templateMean float64
+	for _, x := range template.Pix {
+		templateMean += x
+	}
+	n := float64(len(template.Pix))
+	templateMean /= n
+	centered := make([]float64, len(template.Pix))
+	var templateVariance float64
+	for i, x := range template.Pix {
+		centered[i] = x - templateMean
+		templateVariance += centered[i] * centered[i]
+	}
+	if templateVariance/n < containmentMinVariance {
+		// Flat templates correlate with nothing.
+		return math.Inf(-1), 0, 0
+	}
+
+	maxX := pixels.Width - template.Width
+	maxY := pixels.Height - template.Height
+	score := func(x, y int) float64 {
+		sum, sumSq := sums.Sum(x, y, template.Width, template.Height)
+		variance := sumSq - sum*sum/n
+		if variance/n < containmentMinVariance {
+			return math.Inf(-1)
+		}
+		var dot float64
+		for row := 0; row < template.Height; row++ {
+			imageRow := pixels.Pix[(y+row)*pixels.Width+x:]
+			templateRow := centered[row*template.Width : (row+1)*template.Width]
+			for col, t := range templateRow {
+				dot += t * imageRow[col]
+			}
+		}
+		return dot / math.Sqrt(variance*templateVariance)
+	}
+
+	correlation = math.Inf(-1)
+	for y := 0; y <= maxY; y += 2 {
+		for x := 0; x <= maxX; x += 2 {
+			if s := score(x, y); s > correlation {
+				correlation, bestX, bestY = s, x, y
+			}
+		}
+	}
+	centerX, centerY := bestX, bestY
+	for y := centerY - 1; y <= centerY+1; y++ {
+		for x := centerX - 1; x <= centerX+1; x++ {
+			if x < 0 || y < 0 || x > maxX || y > maxY {
+				continue
+			}
+			if s := score(x, y); s > correlation {
+				correlation, bestX, bestY = s, x, y
+			}
+		}
+	}
+	return
+}
+
+// An integralImage stores cumulative sums of a matrix
+// and its squares, making it possible to compute the sum
+// over any rectangle in constant time.
+type integralImage struct {
+	Width  int
+	Sums   []float64
+	SqSums []float64
+}
+
+func newIntegralImage(g *grayMatrix) *integralImage {
+	width := g.Width + 1
+	res := &integralImage{
+		Width:  width,
+		Sums:   make([]float64, width*(g.Height+1)),
+		SqSums: make([]float64, width*(g.Height+1)),
+	}
+	for y := 0; y < g.Height; y++ {
+		var rowSum, rowSqSum float64
+		for x := 0; x < g.Width; x++ {
+			value := g.At(x, y)
+			rowSum += value
+			rowSqSum += value * value
+			idx := (y+1)*width + x + 1
+			res.Sums[idx] = res.Sums[idx-width] + rowSum
+			res.SqSums[idx] = res.SqSums[idx-width] + rowSqSum
+		}
+	}
+	return res
+}
+
+// Sum computes the sum of the values and of the squared
+// values in a rectangle.
+func (i *integralImage) Sum(x, y, width, height int) (sum, sqSum float64) {
+	topLeft := y*i.Width + x
+	topRight := topLeft + width
+	bottomLeft := (y+height)*i.Width + x
+	bottomRight := bottomLeft + width
+	sum = i.Sums[bottomRight] - i.Sums[bottomLeft] - i.Sums[topRight] + i.Sums[topLeft]
+	sqSum = i.SqSums[bottomRight] - i.SqSums[bottomLeft] - i.SqSums[topRight] +
+		i.SqSums[topLeft]
+	return
+}
+
+// isConvexQuad checks that the corners of a quadrilateral
+// (in order) form a convex shape, as the image of a
+// rectangle under a sensible homography must.
+func isConvexQuad(corners [4][2]float64) bool {
+	var sign float64
+	for i := range corners {
+		a, b, c := corners[i], corners[(i+1)%4], corners[(i+2)%4]
+		cross := (b[0]-a[0])*(c[1]-b[1]) - (b[1]-a[1])*(c[0]-b[0])
+		if cross == 0 || (sign != 0 && (cross > 0) != (sign > 0)) {
+			return false
+		}
+		sign = cross
+	}
+	return true
+}
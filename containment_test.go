@@ -0,0 +1,71 @@
+package samepic
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestContainmentCrop(t *testing.T) {
+	rand.Seed(1337)
+	c := &Containment{}
+	for i := 0; i < 5; i++ {
+		img := smoothImage(300, 200)
+		crop := cropImage(img, 60, 50, 150, 100)
+		if ok, _ := c.Contains(img, crop); !ok {
+			t.Errorf("image %d: crop not found in image", i)
+		}
+		if ok, rect := c.Contains(crop, img); ok {
+			t.Errorf("image %d: image found in its own crop at %v", i, rect)
+		}
+		smallCrop := cropImage(img, 100, 70, 80, 60)
+		if ok, rect := c.Contains(smallCrop, img); ok {
+			t.Errorf("image %d: image found in its own small crop at %v", i, rect)
+		}
+	}
+}
+
+func TestContainmentUnrelated(t *testing.T) {
+	rand.Seed(1338)
+	c := &Containment{}
+	for i := 0; i < 10; i++ {
+		img1 := smoothImage(300, 200)
+		img2 := smoothImage(120, 80)
+		if ok, rect := c.Contains(img1, img2); ok {
+			t.Errorf("pair %d: unrelated image found at %v", i, rect)
+		}
+	}
+}
+
+// smoothImage creates an image out of a few random
+// low-frequency waves, which has little detail for
+// keypoints and correlates easily by chance.
+func smoothImage(width, height int) image.Image {
+	var waves [3][4][3]float64
+	for i := range waves {
+		for j := range waves[i] {
+			waves[i][j] = [3]float64{
+				rand.NormFloat64() * 0.03,
+				rand.NormFloat64() * 0.03,
+				rand.Float64() * 2 * math.Pi,
+			}
+		}
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var channels [3]uint8
+			for i, channelWaves := range waves {
+				var value float64
+				for _, wave := range channelWaves {
+					value += math.Sin(wave[0]*float64(x)+wave[1]*float64(y)+wave[2]) / 4
+				}
+				channels[i] = uint8(127.5 + 127.5*value)
+			}
+			img.SetRGBA(x, y, color.RGBA{channels[0], channels[1], channels[2], 0xff})
+		}
+	}
+	return img
+}
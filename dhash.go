@@ -0,0 +1,124 @@
+package samepic
+
+import "image"
+
+const (
+	DefaultDifferenceHashScaleSize = 8
+	DefaultDifferenceHashThreshold = 0.85
+)
+
+// DifferenceHash compares two images using the
+// difference hash algorithm, which hashes the signs of
+// the gradients between neighboring pixels.
+// See
+// http://www.hackerfactor.com/blog/index.php?/archives/529-Kind-of-Like-That.html.
+//
+// Both horizontal and vertical gradients are hashed, so
+// the hash has 2*ScaleSize*ScaleSize bits.
+type DifferenceHash struct {
+	// ScaleSize is the number of gradients computed
+	// along each axis.
+	// For example, if this is 8, images are scaled to
+	// 9x9 to obtain 8x8 gradients in each direction.
+	//
+	// If this is 0, DefaultDifferenceHashScaleSize is
+	// used.
+	ScaleSize int
+
+	// Threshold is the minimum fraction of hash bits that
+	// must match for two images to be considered the same.
+	//
+	// If this is 0, DefaultDifferenceHashThreshold is used.
+	Threshold float64
+}
+
+// Same computes the hashes of two images and uses the
+// results to determine if the images are the same.
+func (d *DifferenceHash) Same(img1, img2 image.Image) bool {
+	hash1 := d.Hash(img1)
+	hash2 := d.Hash(img2)
+	return hashMatchRatio(hash1, hash2) >= d.threshold()
+}
+
+// SameBatch finds pairs of near duplicates.
+func (d *DifferenceHash) SameBatch(images <-chan *IDImage) <-chan *Pair {
+	return hashSameBatch(images, d.Hash, d.threshold())
+}
+
+// Hash creates the perceptual hash of an image.
+//
+// The first half of the hash contains the horizontal
+// gradient signs and the second half contains the
+// vertical ones, each in row-major order.
+func (d *DifferenceHash) Hash(img image.Image) []bool {
+	scaleSize := d.ScaleSize
+	if scaleSize == 0 {
+		scaleSize = DefaultDifferenceHashScaleSize
+	}
+	stride := scaleSize + 1
+	brightnesses := grayPixels(img, stride, stride)
+
+	res := make([]bool, 0, 2*scaleSize*scaleSize)
+	for y := 0; y < scaleSize; y++ {
+		for x := 0; x < scaleSize; x++ {
+			res = append(res, brightnesses[y*stride+x] < brightnesses[y*stride+x+1])
+		}
+	}
+	for y := 0; y < scaleSize; y++ {
+		for x := 0; x < scaleSize; x++ {
+			res = append(res, brightnesses[y*stride+x] < brightnesses[(y+1)*stride+x])
+		}
+	}
+	return res
+}
+
+// transformHash rearranges the bits of a hash to
+// approximate the hash of a transformed image.
+//
+// Each gradient of the transformed image is a gradient
+// of the original image, possibly along the other axis
+// or in the opposite direction.
+// The hash does not include the gradients along the last
+// row and column of the scaled image, so these are
+// approximated by their neighbors.
+func (d *DifferenceHash) transformHash(hash []bool, transform Dihedral) []bool {
+	cells := len(hash) / 2
+	size := squareSide(cells)
+	stride := float64(size + 1)
+	inverse := transform.Inverse()
+	pixel := func(x, y int) (int, int) {
+		srcX, srcY := inverse.Point(float64(x)+0.5, float64(y)+0.5, stride, stride)
+		return int(srcX), int(srcY)
+	}
+
+	res := make([]bool, 0, len(hash))
+	for axis := 0; axis < 2; axis++ {
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				x1, y1 := pixel(x, y)
+				x2, y2 := pixel(x+1-axis, y+axis)
+				var bit bool
+				if y1 == y2 {
+					row := minInt(y1, size-1)
+					bit = hash[row*size+minInt(x1, x2)] != (x2 < x1)
+				} else {
+					col := minInt(x1, size-1)
+					bit = hash[cells+minInt(y1, y2)*size+col] != (y2 < y1)
+				}
+				res = append(res, bit)
+			}
+		}
+	}
+	return res
+}
+
+func (d *DifferenceHash) matchRatio(h1, h2 []bool) float64 {
+	return hashMatchRatio(h1, h2)
+}
+
+func (d *DifferenceHash) threshold() float64 {
+	if d.Threshold == 0 {
+		return DefaultDifferenceHashThreshold
+	}
+	return d.Threshold
+}
@@ -0,0 +1,145 @@
+package samepic
+
+import (
+	"image"
+	"image/color"
+)
+
+// A Dihedral is one of the eight combinations of 90
+// degree rotations and flips which map a rectangular
+// image onto a (possibly transposed) rectangle.
+type Dihedral int
+
+const (
+	Identity Dihedral = iota
+
+	// Rotate90, Rotate180, and Rotate270 rotate an image
+	// clockwise.
+	Rotate90
+	Rotate180
+	Rotate270
+
+	// FlipHorizontal mirrors an image from left to right.
+	FlipHorizontal
+
+	// FlipVertical mirrors an image from top to bottom.
+	FlipVertical
+
+	// Transpose mirrors an image across its main
+	// diagonal, swapping the x and y axes.
+	Transpose
+
+	// Transverse mirrors an image across its
+	// anti-diagonal.
+	Transverse
+)
+
+// Dihedrals lists every Dihedral, starting with
+// Identity.
+var Dihedrals = []Dihedral{Identity, Rotate90, Rotate180, Rotate270, FlipHorizontal,
+	FlipVertical, Transpose, Transverse}
+
+var dihedralNames = map[Dihedral]string{
+	Identity:       "identity",
+	Rotate90:       "rotate 90",
+	Rotate180:      "rotate 180",
+	Rotate270:      "rotate 270",
+	FlipHorizontal: "flip horizontal",
+	FlipVertical:   "flip vertical",
+	Transpose:      "transpose",
+	Transverse:     "transverse",
+}
+
+// String gets a human-readable name for the transform.
+func (d Dihedral) String() string {
+	if name, ok := dihedralNames[d]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// SwapsAxes checks if the transform exchanges the width
+// and height of an image.
+func (d Dihedral) SwapsAxes() bool {
+	switch d {
+	case Rotate90, Rotate270, Transpose, Transverse:
+		return true
+	default:
+		return false
+	}
+}
+
+// Inverse gets the transform which undoes d.
+func (d Dihedral) Inverse() Dihedral {
+	switch d {
+	case Rotate90:
+		return Rotate270
+	case Rotate270:
+		return Rotate90
+	default:
+		return d
+	}
+}
+
+// Apply transforms an image.
+// The resulting image's bounds start at (0, 0).
+func (d Dihedral) Apply(img image.Image) image.Image {
+	if d == Identity && img.Bounds().Min == (image.Point{}) {
+		return img
+	}
+	return &dihedralImage{Image: img, transform: d}
+}
+
+// Point maps a point from an image with the given size
+// (relative to the image's bounds) to the transformed
+// image.
+// Points are treated as continuous coordinates, so the
+// corners of the image map to corners of the result.
+func (d Dihedral) Point(x, y float64, width, height float64) (float64, float64) {
+	switch d {
+	case Rotate90:
+		return height - y, x
+	case Rotate180:
+		return width - x, height - y
+	case Rotate270:
+		return y, width - x
+	case FlipHorizontal:
+		return width - x, y
+	case FlipVertical:
+		return x, height - y
+	case Transpose:
+		return y, x
+	case Transverse:
+		return height - y, width - x
+	default:
+		return x, y
+	}
+}
+
+// dihedralImage lazily applies a Dihedral to an image.
+type dihedralImage struct {
+	image.Image
+
+	transform Dihedral
+}
+
+func (d *dihedralImage) Bounds() image.Rectangle {
+	b := d.Image.Bounds()
+	if d.transform.SwapsAxes() {
+		return image.Rect(0, 0, b.Dy(), b.Dx())
+	}
+	return image.Rect(0, 0, b.Dx(), b.Dy())
+}
+
+func (d *dihedralImage) At(x, y int) color.Color {
+	b := d.Image.Bounds()
+	width, height := float64(b.Dx()), float64(b.Dy())
+	if d.transform.SwapsAxes() {
+		width, height = height, width
+	}
+
+	// Find the source pixel by applying the inverse
+	// transform to the pixel's center.
+	srcX, srcY := d.transform.Inverse().Point(float64(x)+0.5, float64(y)+0.5, width, height)
+	return d.Image.At(b.Min.X+int(srcX), b.Min.Y+int(srcY))
+}
@@ -0,0 +1,146 @@
+package samepic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+	"io/ioutil"
+)
+
+const exifOrientationTag = 0x0112
+
+// orientationDihedrals maps EXIF orientations to the
+// transforms which display images upright.
+var orientationDihedrals = map[int]Dihedral{
+	1: Identity,
+	2: FlipHorizontal,
+	3: Rotate180,
+	4: FlipVertical,
+	5: Transpose,
+	6: Rotate90,
+	7: Transverse,
+	8: Rotate270,
+}
+
+// An OrientedImage is a decoded image along with the EXIF
+// orientation from its file.
+// The pixels are stored as they were encoded, which may
+// not be upright.
+type OrientedImage struct {
+	image.Image
+
+	// Orientation is the EXIF orientation, from 1 to 8.
+	Orientation int
+}
+
+// Dihedral gets the transform which makes the image
+// upright.
+func (o *OrientedImage) Dihedral() Dihedral {
+	return orientationDihedrals[o.Orientation]
+}
+
+// DecodeImage decodes an image like image.Decode, but
+// also reads the EXIF orientation from JPEG and PNG
+// files.
+//
+// If the image has a non-default orientation, the result
+// is an *OrientedImage, which can be made upright with
+// the CorrectOrientation preprocessor.
+func DecodeImage(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if orientation := exifOrientation(data); orientation > 1 {
+		return &OrientedImage{Image: img, Orientation: orientation}, nil
+	}
+	return img, nil
+}
+
+// exifOrientation finds the EXIF orientation in an
+// encoded JPEG or PNG file, or returns 0 if there is
+// none.
+func exifOrientation(data []byte) int {
+	if bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+		return jpegOrientation(data[2:])
+	} else if bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")) {
+		return pngOrientation(data[8:])
+	}
+	return 0
+}
+
+func jpegOrientation(data []byte) int {
+	for len(data) >= 4 && data[0] == 0xff {
+		marker := data[1]
+		if marker == 0xda || marker == 0xd9 {
+			// Metadata always precedes the image data.
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[2:]))
+		if length < 2 || len(data) < 2+length {
+			break
+		}
+		segment := data[4 : 2+length]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		data = data[2+length:]
+	}
+	return 0
+}
+
+func pngOrientation(data []byte) int {
+	for len(data) >= 12 {
+		length := int(binary.BigEndian.Uint32(data))
+		chunkType := string(data[4:8])
+		if len(data) < 12+length || chunkType == "IDAT" {
+			break
+		}
+		if chunkType == "eXIf" {
+			return tiffOrientation(data[8 : 8+length])
+		}
+		data = data[12+length:]
+	}
+	return 0
+}
+
+// tiffOrientation reads the orientation tag from the
+// first IFD of a TIFF structure.
+func tiffOrientation(data []byte) int {
+	if len(data) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	offset := int(order.Uint32(data[4:]))
+	if offset < 8 || offset+2 > len(data) {
+		return 0
+	}
+	count := int(order.Uint16(data[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(data) {
+			break
+		}
+		if order.Uint16(data[entry:]) == exifOrientationTag {
+			orientation := int(order.Uint16(data[entry+8:]))
+			if _, ok := orientationDihedrals[orientation]; ok {
+				return orientation
+			}
+			return 0
+		}
+	}
+	return 0
+}
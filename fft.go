@@ -0,0 +1,110 @@
+package samepic
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// fft computes the discrete Fourier transform of a list
+// of values in place.
+// The length of the list must be a power of two.
+//
+// If inverse is true, the inverse transform is computed,
+// including the division by the length.
+func fft(values []complex128, inverse bool) {
+	n := len(values)
+
+	// Put the values in bit-reversed order.
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j |= bit
+		if i < j {
+			values[i], values[j] = values[j], values[i]
+		}
+	}
+
+	sign := -1.0
+	if inverse {
+		sign = 1
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Rect(1, sign*2*math.Pi/float64(size))
+		for start := 0; start < n; start += size {
+			twiddle := complex(1, 0)
+			for i := 0; i < size/2; i++ {
+				even := values[start+i]
+				odd := values[start+i+size/2] * twiddle
+				values[start+i] = even + odd
+				values[start+i+size/2] = even - odd
+				twiddle *= step
+			}
+		}
+	}
+
+	if inverse {
+		scale := complex(1/float64(n), 0)
+		for i := range values {
+			values[i] *= scale
+		}
+	}
+}
+
+// A crossCorrelator computes the cross-correlation of a
+// fixed signal with other signals, reusing the fixed
+// signal's transform.
+type crossCorrelator struct {
+	spectrum []complex128
+}
+
+// newCrossCorrelator creates a crossCorrelator for a
+// signal, supporting other signals up to the given
+// length.
+func newCrossCorrelator(signal []float64, maxLength int) *crossCorrelator {
+	size := 1
+	for size < len(signal)+maxLength {
+		size <<= 1
+	}
+	spectrum := make([]complex128, size)
+	for i, x := range signal {
+		spectrum[i] = complex(x, 0)
+	}
+	fft(spectrum, false)
+	return &crossCorrelator{spectrum: spectrum}
+}
+
+// Correlate computes the dot product of the fixed signal
+// and another signal for every offset of the other
+// signal, where the dot product at offset x is the sum of
+// signal[i+x]*other[i] over the overlapping indices.
+//
+// The result is indexed by offset, with negative offsets
+// wrapping around to the end.
+func (c *crossCorrelator) Correlate(other []float64) []float64 {
+	spectrum := make([]complex128, len(c.spectrum))
+	for i, x := range other {
+		spectrum[i] = complex(x, 0)
+	}
+	fft(spectrum, false)
+	for i, x := range spectrum {
+		spectrum[i] = c.spectrum[i] * cmplx.Conj(x)
+	}
+	fft(spectrum, true)
+	res := make([]float64, len(spectrum))
+	for i, x := range spectrum {
+		res[i] = real(x)
+	}
+	return res
+}
+
+// squarePrefixSums computes the sums of the squares of
+// the first i values, for i from 0 to len(values).
+func squarePrefixSums(values []float64) []float64 {
+	res := make([]float64, len(values)+1)
+	for i, x := range values {
+		res[i+1] = res[i] + x*x
+	}
+	return res
+}
@@ -3,6 +3,7 @@ package samepic
 import (
 	"errors"
 	"flag"
+	"image/color"
 )
 
 // Flags is used to create a Samer from command-line
@@ -11,32 +12,157 @@ type Flags struct {
 	Name       string
 	NeuralPath string
 	Threshold  float64
+	HistMetric string
+	Alpha      string
+	SquashAxis string
+	Invariant  bool
+	Mask       string
+	AutoMask   bool
+	Preprocess string
+	Guard      bool
+
+	// Rejected is passed to the GuardedSamer created for
+	// the -guard flag.
+	Rejected func(id interface{})
 }
 
 // AddToSet adds the struct fields of f as arguments to
 // the flag set.
 func (f *Flags) AddToSet(set *flag.FlagSet) {
 	set.StringVar(&f.Name, "samer", "", "name of samer "+
-		"(avghash, colorprof, squashcomp, or neuralnet)")
+		"(avghash, dhash, phash, whash, blockmean, radialvar, ssim, "+
+		"colorprof, colormoment, hog, keypoint, squashcomp, or neuralnet)")
 	set.StringVar(&f.NeuralPath, "netpath", "", "path to neural network "+
 		"(for neuralnet samer)")
 	set.Float64Var(&f.Threshold, "threshold", 0, "threshold "+
 		"(applicable for most samers)")
+	set.StringVar(&f.HistMetric, "histmetric", "", "histogram metric "+
+		"(correlation, intersection, chisquare, bhattacharyya, or emd; "+
+		"for colorprof samer)")
+	set.StringVar(&f.Alpha, "alpha", "", "handling of transparency "+
+		"(ignore, composite[:rrggbb], mask, or compare; "+
+		"mask and compare are only for avghash and colorprof samers)")
+	set.StringVar(&f.SquashAxis, "squashaxis", "", "squash axis "+
+		"(vertical, horizontal, both, or 2d; for squashcomp samer)")
+	set.BoolVar(&f.Invariant, "invariant", false, "also match flipped "+
+		"and rotated images")
+	set.StringVar(&f.Mask, "mask", "", "semicolon-separated regions "+
+		"to ignore, each as normalized minx,miny,maxx,maxy")
+	set.BoolVar(&f.AutoMask, "automask", false, "ignore the regions "+
+		"where each pair of images differs the most")
+	set.StringVar(&f.Preprocess, "preprocess", "", "comma-separated "+
+		"preprocessors (trim, orient, flatten[:rrggbb], equalize, grayscale)")
+	set.BoolVar(&f.Guard, "guard", false, "never match blank, uniform, "+
+		"or tiny images")
 }
 
 // Samer creates a samer from the parsed flags.
 func (f *Flags) Samer() (Samer, error) {
+	alphaMode, alphaBackground := IgnoreAlpha, color.RGBA{}
+	if f.Alpha != "" {
+		var err error
+		alphaMode, alphaBackground, err = ParseAlphaMode(f.Alpha)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	samer, err := f.baseSamer(alphaMode, alphaBackground)
+	if err != nil {
+		return nil, err
+	}
+	if f.Name != "avghash" && f.Name != "colorprof" {
+		// Other samers have no native alpha support, but
+		// any of them can compare composited images.
+		switch alphaMode {
+		case CompositeAlpha:
+			samer = &PreprocessedSamer{
+				Samer:         samer,
+				Preprocessors: []Preprocessor{&FlattenAlpha{Background: alphaBackground}},
+			}
+		case MaskAlpha, CompareAlpha:
+			return nil, errors.New("-alpha " + f.Alpha + " is not supported by samer: " + f.Name)
+		}
+	}
+	if f.Invariant {
+		samer = &Invariant{Samer: samer}
+	}
+	if f.Mask != "" || f.AutoMask {
+		mask, err := ParseMask(f.Mask)
+		if err != nil {
+			return nil, err
+		}
+		samer = &MaskedSamer{Samer: samer, Mask: mask, AutoMask: f.AutoMask}
+	}
+	if f.Guard {
+		samer = &GuardedSamer{Samer: samer, Rejected: f.Rejected}
+	}
+	if f.Preprocess != "" {
+		preprocessors, err := ParsePreprocessors(f.Preprocess)
+		if err != nil {
+			return nil, err
+		}
+		samer = &PreprocessedSamer{Samer: samer, Preprocessors: preprocessors}
+	}
+	return samer, nil
+}
+
+// baseSamer creates the samer named by the -samer flag,
+// passing the alpha mode to samers which support it.
+func (f *Flags) baseSamer(alphaMode AlphaMode, alphaBackground color.RGBA) (Samer, error) {
 	if f.Name == "" {
 		return nil, errors.New("missing -samer flag")
 	}
 
 	switch f.Name {
 	case "avghash":
-		return &AverageHash{Threshold: f.Threshold}, nil
+		return &AverageHash{
+			Threshold:       f.Threshold,
+			AlphaMode:       alphaMode,
+			AlphaBackground: alphaBackground,
+		}, nil
+	case "dhash":
+		return &DifferenceHash{Threshold: f.Threshold}, nil
+	case "phash":
+		return &PerceptualHash{Threshold: f.Threshold}, nil
+	case "whash":
+		return &WaveletHash{Threshold: f.Threshold}, nil
+	case "blockmean":
+		return &BlockMeanHash{Threshold: f.Threshold}, nil
+	case "radialvar":
+		return &RadialVarianceHash{Threshold: f.Threshold}, nil
+	case "ssim":
+		return &SSIM{Threshold: f.Threshold}, nil
 	case "colorprof":
-		return &ColorProf{Threshold: f.Threshold}, nil
+		res := &ColorProf{
+			Threshold:       f.Threshold,
+			AlphaMode:       alphaMode,
+			AlphaBackground: alphaBackground,
+		}
+		if f.HistMetric != "" {
+			metric, err := ParseHistogramMetric(f.HistMetric)
+			if err != nil {
+				return nil, err
+			}
+			res.Metric = metric
+		}
+		return res, nil
+	case "colormoment":
+		return &ColorMomentHash{Cutoff: f.Threshold}, nil
+	case "hog":
+		return &HOG{Threshold: f.Threshold}, nil
+	case "keypoint":
+		return &KeypointSamer{MinInliers: int(f.Threshold)}, nil
 	case "squashcomp":
-		return &SquashComp{Threshold: f.Threshold}, nil
+		res := &SquashComp{Threshold: f.Threshold}
+		if f.SquashAxis != "" {
+			axis, err := ParseSquashAxis(f.SquashAxis)
+			if err != nil {
+				return nil, err
+			}
+			res.Axis = axis
+		}
+		return res, nil
 	case "neuralnet":
 		if f.NeuralPath == "" {
 			return nil, errors.New("missing -netpath flag")
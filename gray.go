@@ -0,0 +1,117 @@
+package samepic
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/nfnt/resize"
+)
+
+// grayPixels scales an image to the given size and
+// returns the brightness of each pixel (in row-major
+// order) as a value between 0 and 1.
+func grayPixels(img image.Image, width, height int) []float64 {
+	scaled := resize.Resize(uint(width), uint(height), img, resize.Bilinear)
+	res := make([]float64, 0, width*height)
+	for y := scaled.Bounds().Min.Y; y < scaled.Bounds().Max.Y; y++ {
+		for x := scaled.Bounds().Min.X; x < scaled.Bounds().Max.X; x++ {
+			gray := color.GrayModel.Convert(scaled.At(x, y))
+			r, _, _, _ := gray.RGBA()
+			res = append(res, float64(r)/0xffff)
+		}
+	}
+	return res
+}
+
+// A grayMatrix is a grayscale image stored as a
+// row-major matrix of brightness values.
+type grayMatrix struct {
+	Width  int
+	Height int
+	Pix    []float64
+}
+
+// newGrayMatrix scales an image to the given size and
+// converts it to grayscale.
+func newGrayMatrix(img image.Image, width, height int) *grayMatrix {
+	return &grayMatrix{
+		Width:  width,
+		Height: height,
+		Pix:    grayPixels(img, width, height),
+	}
+}
+
+// At gets the brightness at the given coordinates.
+func (g *grayMatrix) At(x, y int) float64 {
+	return g.Pix[y*g.Width+x]
+}
+
+// Crop extracts a rectangular region of the matrix.
+func (g *grayMatrix) Crop(x, y, width, height int) *grayMatrix {
+	res := &grayMatrix{Width: width, Height: height, Pix: make([]float64, 0, width*height)}
+	for row := y; row < y+height; row++ {
+		res.Pix = append(res.Pix, g.Pix[row*g.Width+x:row*g.Width+x+width]...)
+	}
+	return res
+}
+
+// Resize scales the matrix to a new size using bilinear
+// interpolation.
+func (g *grayMatrix) Resize(width, height int) *grayMatrix {
+	res := &grayMatrix{Width: width, Height: height, Pix: make([]float64, width*height)}
+	scaleX := float64(g.Width) / float64(width)
+	scaleY := float64(g.Height) / float64(height)
+	for y := 0; y < height; y++ {
+		srcY := math.Max(0, (float64(y)+0.5)*scaleY-0.5)
+		y0 := int(srcY)
+		y1 := clampInt(y0+1, 0, g.Height-1)
+		fracY := srcY - float64(y0)
+		for x := 0; x < width; x++ {
+			srcX := math.Max(0, (float64(x)+0.5)*scaleX-0.5)
+			x0 := int(srcX)
+			x1 := clampInt(x0+1, 0, g.Width-1)
+			fracX := srcX - float64(x0)
+			top := g.At(x0, y0)*(1-fracX) + g.At(x1, y0)*fracX
+			bottom := g.At(x0, y1)*(1-fracX) + g.At(x1, y1)*fracX
+			res.Pix[y*width+x] = top*(1-fracY) + bottom*fracY
+		}
+	}
+	return res
+}
+
+// Downsample halves the size of the matrix by averaging
+// 2x2 blocks.
+func (g *grayMatrix) Downsample() *grayMatrix {
+	width, height := g.Width/2, g.Height/2
+	res := &grayMatrix{Width: width, Height: height, Pix: make([]float64, width*height)}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			res.Pix[y*width+x] = (g.At(2*x, 2*y) + g.At(2*x+1, 2*y) +
+				g.At(2*x, 2*y+1) + g.At(2*x+1, 2*y+1)) / 4
+		}
+	}
+	return res
+}
+
+// BoxBlur averages each pixel with its neighbors in a
+// square of the given radius, ignoring neighbors which
+// are out of bounds.
+func (g *grayMatrix) BoxBlur(radius int) *grayMatrix {
+	res := &grayMatrix{Width: g.Width, Height: g.Height, Pix: make([]float64, len(g.Pix))}
+	for y := 0; y < g.Height; y++ {
+		for x := 0; x < g.Width; x++ {
+			var sum, count float64
+			for by := y - radius; by <= y+radius; by++ {
+				for bx := x - radius; bx <= x+radius; bx++ {
+					if bx >= 0 && by >= 0 && bx < g.Width && by < g.Height {
+						sum += g.At(bx, by)
+						count++
+					}
+				}
+			}
+			res.Pix[y*g.Width+x] = sum / count
+		}
+	}
+	return res
+}
@@ -0,0 +1,166 @@
+package samepic
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	DefaultInfoGuardMinSize    = 16
+	DefaultInfoGuardMinEntropy = 1
+	DefaultInfoGuardMinStdDev  = 0.03
+)
+
+const (
+	infoGuardScaleSize = 64
+	infoGuardBinCount  = 32
+)
+
+// A Decision is the outcome of comparing two images with
+// a GuardedSamer.
+type Decision int
+
+const (
+	DifferentDecision Decision = iota
+	SameDecision
+
+	// UndecidableDecision means that at least one of the
+	// images has too little information to be compared.
+	UndecidableDecision
+)
+
+// An InfoGuard detects low-information images, such as
+// solid colors, blank documents, and tracking pixels.
+// Most samers consider such images the same as each other,
+// since correlations and means degenerate when there is
+// nothing to compare.
+type InfoGuard struct {
+	// MinSize is the minimum width and height of an image.
+	//
+	// If this is 0, DefaultInfoGuardMinSize is used.
+	MinSize int
+
+	// MinEntropy is the minimum entropy, in bits, of the
+	// histogram of an image's brightness.
+	//
+	// If this is 0, DefaultInfoGuardMinEntropy is used.
+	MinEntropy float64
+
+	// MinStdDev is the minimum standard deviation of an
+	// image's brightness, which ranges from 0 to 1.
+	//
+	// If this is 0, DefaultInfoGuardMinStdDev is used.
+	MinStdDev float64
+}
+
+// LowInformation checks if an image is too small or too
+// uniform to be compared meaningfully.
+func (g *InfoGuard) LowInformation(img image.Image) bool {
+	minSize, minEntropy, minStdDev := g.parameters()
+	if img.Bounds().Dx() < minSize || img.Bounds().Dy() < minSize {
+		return true
+	}
+
+	pixels := grayPixels(img, infoGuardScaleSize, infoGuardScaleSize)
+	var histogram [infoGuardBinCount]float64
+	var mean float64
+	for _, x := range pixels {
+		histogram[clampInt(int(x*infoGuardBinCount), 0, infoGuardBinCount-1)]++
+		mean += x
+	}
+	mean /= float64(len(pixels))
+
+	var entropy float64
+	for _, count := range histogram {
+		if count > 0 {
+			prob := count / float64(len(pixels))
+			entropy -= prob * math.Log2(prob)
+		}
+	}
+
+	var variance float64
+	for _, x := range pixels {
+		variance += (x - mean) * (x - mean)
+	}
+	variance /= float64(len(pixels))
+
+	return entropy < minEntropy || math.Sqrt(variance) < minStdDev
+}
+
+func (g *InfoGuard) parameters() (minSize int, minEntropy, minStdDev float64) {
+	minSize, minEntropy, minStdDev = g.MinSize, g.MinEntropy, g.MinStdDev
+	if minSize == 0 {
+		minSize = DefaultInfoGuardMinSize
+	}
+	if minEntropy == 0 {
+		minEntropy = DefaultInfoGuardMinEntropy
+	}
+	if minStdDev == 0 {
+		minStdDev = DefaultInfoGuardMinStdDev
+	}
+	return
+}
+
+// GuardedSamer wraps a Samer, refusing to match images
+// which an InfoGuard considers low-information.
+type GuardedSamer struct {
+	Samer Samer
+
+	// Guard detects low-information images.
+	// If this is nil, an InfoGuard with default
+	// parameters is used.
+	Guard *InfoGuard
+
+	// Rejected, if non-nil, is called by SameBatch with
+	// the ID of each low-information image.
+	Rejected func(id interface{})
+}
+
+// Decide compares two images, or reports that they can't
+// be compared.
+func (g *GuardedSamer) Decide(img1, img2 image.Image) Decision {
+	if g.guard().LowInformation(img1) || g.guard().LowInformation(img2) {
+		return UndecidableDecision
+	} else if g.Samer.Same(img1, img2) {
+		return SameDecision
+	}
+	return DifferentDecision
+}
+
+// Same checks if the images are the same, treating
+// undecidable comparisons as different.
+func (g *GuardedSamer) Same(img1, img2 image.Image) bool {
+	return g.Decide(img1, img2) == SameDecision
+}
+
+// SameBatch finds pairs of near duplicates, leaving out
+// low-information images.
+//
+// If the wrapped Samer is not a BatchSamer, every pair of
+// images is compared with Same.
+func (g *GuardedSamer) SameBatch(images <-chan *IDImage) <-chan *Pair {
+	filtered := make(chan *IDImage, 1)
+	go func() {
+		defer close(filtered)
+		for image := range images {
+			if g.guard().LowInformation(image.Image) {
+				if g.Rejected != nil {
+					g.Rejected(image.ID)
+				}
+				continue
+			}
+			filtered <- image
+		}
+	}()
+	if batchSamer, ok := g.Samer.(BatchSamer); ok {
+		return batchSamer.SameBatch(filtered)
+	}
+	return pairwiseSameBatch(g.Samer, filtered)
+}
+
+func (g *GuardedSamer) guard() *InfoGuard {
+	if g.Guard == nil {
+		return &InfoGuard{}
+	}
+	return g.Guard
+}
@@ -0,0 +1,168 @@
+package samepic
+
+import (
+	"errors"
+	"math"
+
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+// A HistogramMetric is a way of measuring the similarity
+// between two histograms.
+type HistogramMetric int
+
+const (
+	// CorrelationMetric measures the cosine similarity
+	// between the raw histograms.
+	CorrelationMetric HistogramMetric = iota
+
+	// IntersectionMetric sums the minimum of each pair
+	// of bins in the normalized histograms.
+	IntersectionMetric
+
+	// ChiSquareMetric is one minus the (symmetric)
+	// chi-square distance between the normalized
+	// histograms.
+	ChiSquareMetric
+
+	// BhattacharyyaMetric is one minus the Hellinger
+	// distance, which is derived from the Bhattacharyya
+	// coefficient of the normalized histograms.
+	BhattacharyyaMetric
+
+	// EMDMetric is one minus the Earth Mover's Distance
+	// between the normalized histograms, where moving
+	// all of the mass from the first bin to the last bin
+	// has a distance of one.
+	// Joint histograms are compared by the mean EMD of
+	// their three marginal histograms.
+	EMDMetric
+)
+
+var histogramMetricNames = map[string]HistogramMetric{
+	"correlation":   CorrelationMetric,
+	"intersection":  IntersectionMetric,
+	"chisquare":     ChiSquareMetric,
+	"bhattacharyya": BhattacharyyaMetric,
+	"emd":           EMDMetric,
+}
+
+// ParseHistogramMetric finds the metric with the given
+// name (correlation, intersection, chisquare,
+// bhattacharyya, or emd).
+func ParseHistogramMetric(name string) (HistogramMetric, error) {
+	if metric, ok := histogramMetricNames[name]; ok {
+		return metric, nil
+	}
+	return 0, errors.New("unknown histogram metric: " + name)
+}
+
+// defaultThreshold gets the default similarity
+// threshold for ColorProf when using this metric.
+func (h HistogramMetric) defaultThreshold() float64 {
+	switch h {
+	case CorrelationMetric:
+		return DefaultColorProfThreshold
+	case IntersectionMetric:
+		return DefaultColorProfIntersectionThreshold
+	case ChiSquareMetric:
+		return DefaultColorProfChiSquareThreshold
+	case BhattacharyyaMetric:
+		return DefaultColorProfBhattacharyyaThreshold
+	case EMDMetric:
+		return DefaultColorProfEMDThreshold
+	default:
+		panic("unknown histogram metric")
+	}
+}
+
+// similarity compares two histograms which have already
+// been normalized to sum to one.
+// The binCount is only used by EMDMetric, to find the
+// marginals of joint histograms.
+func (h HistogramMetric) similarity(hist1, hist2 linalg.Vector, binCount int) float64 {
+	switch h {
+	case CorrelationMetric:
+		return hist1.Dot(hist2) / (hist1.Mag() * hist2.Mag())
+	case IntersectionMetric:
+		var sum float64
+		for i, x := range hist1 {
+			sum += math.Min(x, hist2[i])
+		}
+		return sum
+	case ChiSquareMetric:
+		var sum float64
+		for i, x := range hist1 {
+			if total := x + hist2[i]; total > 0 {
+				sum += (x - hist2[i]) * (x - hist2[i]) / total
+			}
+		}
+		return 1 - sum/2
+	case BhattacharyyaMetric:
+		var coeff float64
+		for i, x := range hist1 {
+			coeff += math.Sqrt(x * hist2[i])
+		}
+		return 1 - math.Sqrt(math.Max(0, 1-coeff))
+	case EMDMetric:
+		if len(hist1) == binCount {
+			return 1 - earthMoversDistance(hist1, hist2)
+		}
+		marginals1 := jointMarginals(hist1, binCount)
+		marginals2 := jointMarginals(hist2, binCount)
+		var sum float64
+		for i, marginal := range marginals1 {
+			sum += earthMoversDistance(marginal, marginals2[i])
+		}
+		return 1 - sum/3
+	default:
+		panic("unknown histogram metric")
+	}
+}
+
+// earthMoversDistance computes the EMD between two
+// one-dimensional normalized histograms, scaled so that
+// adjacent bins are 1/(n-1) apart.
+func earthMoversDistance(hist1, hist2 linalg.Vector) float64 {
+	if len(hist1) < 2 {
+		return 0
+	}
+	var cumulative, sum float64
+	for i, x := range hist1 {
+		cumulative += x - hist2[i]
+		sum += math.Abs(cumulative)
+	}
+	return sum / float64(len(hist1)-1)
+}
+
+// jointMarginals sums a joint histogram along pairs of
+// axes to get the histogram for each axis.
+func jointMarginals(joint linalg.Vector, binCount int) [3]linalg.Vector {
+	var res [3]linalg.Vector
+	for i := range res {
+		res[i] = make(linalg.Vector, binCount)
+	}
+	for idx, x := range joint {
+		res[0][idx/(binCount*binCount)] += x
+		res[1][(idx/binCount)%binCount] += x
+		res[2][idx%binCount] += x
+	}
+	return res
+}
+
+// normalizeHistogram scales a histogram so that it sums
+// to one.
+func normalizeHistogram(hist linalg.Vector) linalg.Vector {
+	var sum float64
+	for _, x := range hist {
+		sum += x
+	}
+	res := make(linalg.Vector, len(hist))
+	if sum == 0 {
+		return res
+	}
+	for i, x := range hist {
+		res[i] = x / sum
+	}
+	return res
+}
@@ -0,0 +1,187 @@
+package samepic
+
+import (
+	"image"
+	"math"
+
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+const (
+	DefaultHOGScaleSize = 64
+	DefaultHOGCellSize  = 8
+	DefaultHOGBinCount  = 9
+	DefaultHOGBlockSize = 2
+	DefaultHOGThreshold = 0.75
+)
+
+// HOG compares images using histograms of oriented
+// gradients, which describe the edge structure of an
+// image independently of its colors.
+// This allows recolored, grayscaled, or filtered copies
+// of an image to match.
+//
+// Gradient orientations are unsigned, so an edge from
+// dark to light matches the same edge from light to
+// dark.
+type HOG struct {
+	// ScaleSize is the size to which images are scaled
+	// before computing gradients.
+	//
+	// If this is 0, DefaultHOGScaleSize is used.
+	ScaleSize int
+
+	// CellSize is the width and height of the cells for
+	// which gradient histograms are computed.
+	//
+	// If this is 0, DefaultHOGCellSize is used.
+	CellSize int
+
+	// BinCount is the number of orientation bins in each
+	// cell's histogram.
+	//
+	// If this is 0, DefaultHOGBinCount is used.
+	BinCount int
+
+	// BlockSize is the width and height, in cells, of the
+	// overlapping blocks over which histograms are
+	// normalized.
+	//
+	// If this is 0, DefaultHOGBlockSize is used.
+	BlockSize int
+
+	// Threshold is the minimum cosine similarity between
+	// two descriptors for the images to be considered the
+	// same.
+	//
+	// If this is 0, DefaultHOGThreshold is used.
+	Threshold float64
+}
+
+// Same compares the descriptors of two images.
+func (h *HOG) Same(img1, img2 image.Image) bool {
+	return h.match(h.Descriptor(img1), h.Descriptor(img2))
+}
+
+// SameBatch finds pairs of near duplicates.
+func (h *HOG) SameBatch(images <-chan *IDImage) <-chan *Pair {
+	res := make(chan *Pair, 1)
+	go func() {
+		defer close(res)
+		ids := []interface{}{}
+		descs := []linalg.Vector{}
+		for image := range images {
+			desc := h.Descriptor(image.Image)
+			for i, desc1 := range descs {
+				if h.match(desc, desc1) {
+					res <- &Pair{ids[i], image.ID}
+				}
+			}
+			ids = append(ids, image.ID)
+			descs = append(descs, desc)
+		}
+	}()
+	return res
+}
+
+// Descriptor computes the HOG descriptor of an image.
+//
+// The descriptor is the concatenation of the normalized
+// histograms of every cell in every block, with blocks
+// spaced one cell apart.
+func (h *HOG) Descriptor(img image.Image) linalg.Vector {
+	scaleSize, cellSize, binCount, blockSize := h.parameters()
+	pixels := newGrayMatrix(img, scaleSize, scaleSize)
+
+	cellCount := scaleSize / cellSize
+	cells := make([]linalg.Vector, cellCount*cellCount)
+	for i := range cells {
+		cells[i] = make(linalg.Vector, binCount)
+	}
+	for y := 0; y < cellCount*cellSize; y++ {
+		for x := 0; x < cellCount*cellSize; x++ {
+			dx := pixels.At(clampInt(x+1, 0, scaleSize-1), y) -
+				pixels.At(clampInt(x-1, 0, scaleSize-1), y)
+			dy := pixels.At(x, clampInt(y+1, 0, scaleSize-1)) -
+				pixels.At(x, clampInt(y-1, 0, scaleSize-1))
+			magnitude := math.Hypot(dx, dy)
+			if magnitude == 0 {
+				continue
+			}
+			angle := math.Atan2(dy, dx)
+			if angle < 0 {
+				angle += math.Pi
+			}
+
+			// Split the vote between the two nearest bins.
+			bin := angle/math.Pi*float64(binCount) - 0.5
+			lowBin := int(math.Floor(bin))
+			frac := bin - float64(lowBin)
+			cell := cells[(y/cellSize)*cellCount+x/cellSize]
+			cell[(lowBin+binCount)%binCount] += magnitude * (1 - frac)
+			cell[(lowBin+1)%binCount] += magnitude * frac
+		}
+	}
+
+	var res linalg.Vector
+	for blockY := 0; blockY+blockSize <= cellCount; blockY++ {
+		for blockX := 0; blockX+blockSize <= cellCount; blockX++ {
+			var block linalg.Vector
+			for y := blockY; y < blockY+blockSize; y++ {
+				for x := blockX; x < blockX+blockSize; x++ {
+					block = append(block, cells[y*cellCount+x]...)
+				}
+			}
+			res = append(res, normalizeHOGBlock(block)...)
+		}
+	}
+	return res
+}
+
+func (h *HOG) parameters() (scaleSize, cellSize, binCount, blockSize int) {
+	scaleSize, cellSize, binCount, blockSize = h.ScaleSize, h.CellSize, h.BinCount, h.BlockSize
+	if scaleSize == 0 {
+		scaleSize = DefaultHOGScaleSize
+	}
+	if cellSize == 0 {
+		cellSize = DefaultHOGCellSize
+	}
+	if cellSize > scaleSize {
+		cellSize = scaleSize
+	}
+	if binCount == 0 {
+		binCount = DefaultHOGBinCount
+	}
+	if blockSize == 0 {
+		blockSize = DefaultHOGBlockSize
+	}
+	if blockSize > scaleSize/cellSize {
+		blockSize = scaleSize / cellSize
+	}
+	return
+}
+
+func (h *HOG) match(desc1, desc2 linalg.Vector) bool {
+	threshold := h.Threshold
+	if threshold == 0 {
+		threshold = DefaultHOGThreshold
+	}
+	mags := desc1.Mag() * desc2.Mag()
+	if mags == 0 {
+		return desc1.Mag() == desc2.Mag()
+	}
+	return desc1.Dot(desc2)/mags >= threshold
+}
+
+// normalizeHOGBlock applies L2-Hys normalization to a
+// block: the block is L2 normalized, clipped, and then
+// normalized again.
+func normalizeHOGBlock(block linalg.Vector) linalg.Vector {
+	const clip = 0.2
+	const epsilon = 1e-5
+	block = block.Copy().Scale(1 / (block.Mag() + epsilon))
+	for i, x := range block {
+		block[i] = math.Min(x, clip)
+	}
+	return block.Scale(1 / (block.Mag() + epsilon))
+}
@@ -0,0 +1,161 @@
+package samepic
+
+import "math"
+
+// A homography is a 3x3 projective transformation
+// matrix, stored in row-major order.
+type homography [9]float64
+
+// Apply transforms a point with the homography.
+func (h homography) Apply(x, y float64) (float64, float64) {
+	w := h[6]*x + h[7]*y + h[8]
+	return (h[0]*x + h[1]*y + h[2]) / w, (h[3]*x + h[4]*y + h[5]) / w
+}
+
+// Mul computes the product h*h1, which applies h1 and
+// then h.
+func (h homography) Mul(h1 homography) homography {
+	var res homography
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			for i := 0; i < 3; i++ {
+				res[row*3+col] += h[row*3+i] * h1[i*3+col]
+			}
+		}
+	}
+	return res
+}
+
+// Inverse computes the inverse transformation.
+// The second return value is false if the homography is
+// singular.
+func (h homography) Inverse() (homography, bool) {
+	cofactors := homography{
+		h[4]*h[8] - h[5]*h[7], h[2]*h[7] - h[1]*h[8], h[1]*h[5] - h[2]*h[4],
+		h[5]*h[6] - h[3]*h[8], h[0]*h[8] - h[2]*h[6], h[2]*h[3] - h[0]*h[5],
+		h[3]*h[7] - h[4]*h[6], h[1]*h[6] - h[0]*h[7], h[0]*h[4] - h[1]*h[3],
+	}
+	det := h[0]*cofactors[0] + h[1]*cofactors[3] + h[2]*cofactors[6]
+	if math.Abs(det) < 1e-12 {
+		return homography{}, false
+	}
+	for i := range cofactors {
+		cofactors[i] /= det
+	}
+	return cofactors, true
+}
+
+// fitHomography finds the homography which maps each
+// source point to the corresponding destination point.
+//
+// With exactly four points, the fit is exact.
+// With more points, it is a least-squares fit.
+// The second return value is false if the points are
+// degenerate (e.g. three or more are collinear).
+func fitHomography(src, dst [][2]float64) (homography, bool) {
+	if len(src) < 4 || len(src) != len(dst) {
+		return homography{}, false
+	}
+
+	// Normalizing the points keeps the normal equations
+	// well conditioned when working with pixel coordinates.
+	srcNorm, srcPoints := normalizePoints(src)
+	dstNorm, dstPoints := normalizePoints(dst)
+
+	var ata [8][8]float64
+	var atb [8]float64
+	addRow := func(row [8]float64, b float64) {
+		for i := 0; i < 8; i++ {
+			for j := 0; j < 8; j++ {
+				ata[i][j] += row[i] * row[j]
+			}
+			atb[i] += row[i] * b
+		}
+	}
+	for i, s := range srcPoints {
+		d := dstPoints[i]
+		addRow([8]float64{s[0], s[1], 1, 0, 0, 0, -s[0] * d[0], -s[1] * d[0]}, d[0])
+		addRow([8]float64{0, 0, 0, s[0], s[1], 1, -s[0] * d[1], -s[1] * d[1]}, d[1])
+	}
+
+	solution, ok := solveLinear(ata, atb)
+	if !ok {
+		return homography{}, false
+	}
+	var normalized homography
+	copy(normalized[:], solution[:])
+	normalized[8] = 1
+
+	dstDenorm, ok := dstNorm.Inverse()
+	if !ok {
+		return homography{}, false
+	}
+	return dstDenorm.Mul(normalized).Mul(srcNorm), true
+}
+
+// normalizePoints translates and scales points so that
+// their centroid is at the origin and their average
+// distance from the origin is sqrt(2).
+// It returns the normalizing transformation along with
+// the normalized points.
+func normalizePoints(points [][2]float64) (homography, [][2]float64) {
+	var meanX, meanY float64
+	for _, p := range points {
+		meanX += p[0]
+		meanY += p[1]
+	}
+	meanX /= float64(len(points))
+	meanY /= float64(len(points))
+
+	var meanDist float64
+	for _, p := range points {
+		meanDist += math.Hypot(p[0]-meanX, p[1]-meanY)
+	}
+	meanDist /= float64(len(points))
+	scale := 1.0
+	if meanDist > 0 {
+		scale = math.Sqrt2 / meanDist
+	}
+
+	norm := homography{scale, 0, -scale * meanX, 0, scale, -scale * meanY, 0, 0, 1}
+	res := make([][2]float64, len(points))
+	for i, p := range points {
+		res[i] = [2]float64{scale * (p[0] - meanX), scale * (p[1] - meanY)}
+	}
+	return norm, res
+}
+
+// solveLinear solves a linear system using Gaussian
+// elimination with partial pivoting.
+func solveLinear(a [8][8]float64, b [8]float64) ([8]float64, bool) {
+	const n = 8
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return b, false
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		b[col], b[pivot] = b[pivot], b[col]
+		for row := col + 1; row < n; row++ {
+			factor := a[row][col] / a[col][col]
+			for i := col; i < n; i++ {
+				a[row][i] -= factor * a[col][i]
+			}
+			b[row] -= factor * b[col]
+		}
+	}
+	var res [8]float64
+	for row := n - 1; row >= 0; row-- {
+		sum := b[row]
+		for i := row + 1; i < n; i++ {
+			sum -= a[row][i] * res[i]
+		}
+		res[row] = sum / a[row][row]
+	}
+	return res, true
+}
@@ -0,0 +1,220 @@
+package samepic
+
+import (
+	"image"
+	"sync"
+)
+
+// Invariant wraps a Samer so that it also matches images
+// which have been rotated by multiples of 90 degrees or
+// flipped.
+//
+// The second image is compared to the first image under
+// every Dihedral, and the images are the same if any of
+// the comparisons succeeds.
+// For hash samers like AverageHash and PerceptualHash,
+// the transformed images are usually never hashed;
+// instead, the bits of the original hash are rearranged.
+type Invariant struct {
+	Samer Samer
+
+	// Dihedrals lists the transforms to try.
+	// If this is nil, all of the Dihedrals are used.
+	Dihedrals []Dihedral
+}
+
+// A dihedralHasher is a hash samer which can transform a
+// hash to (approximately) get the hash of a transformed
+// image.
+// If transformHash returns nil, the hash can't be
+// transformed, and the transformed image is hashed
+// instead.
+type dihedralHasher interface {
+	Hash(img image.Image) []bool
+	transformHash(hash []bool, d Dihedral) []bool
+	matchRatio(h1, h2 []bool) float64
+	threshold() float64
+}
+
+// Same checks if any transformed version of img2 is the
+// same as img1.
+func (i *Invariant) Same(img1, img2 image.Image) bool {
+	if hasher, ok := i.Samer.(dihedralHasher); ok {
+		hash1 := hasher.Hash(img1)
+		for _, hash2 := range i.hashVariants(hasher, img2, hasher.Hash(img2)) {
+			if hasher.matchRatio(hash1, hash2) >= hasher.threshold() {
+				return true
+			}
+		}
+		return false
+	}
+	for _, d := range i.dihedrals() {
+		if i.Samer.Same(img1, d.Apply(img2)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SameBatch finds pairs of near duplicates.
+//
+// If the wrapped Samer is a BatchSamer, it is fed every
+// image along with its transformed versions, and only
+// the pairs in which one image is untransformed count.
+// Otherwise, every pair of images is compared with Same.
+func (i *Invariant) SameBatch(images <-chan *IDImage) <-chan *Pair {
+	if hasher, ok := i.Samer.(dihedralHasher); ok {
+		return i.hashSameBatch(hasher, images)
+	} else if batchSamer, ok := i.Samer.(BatchSamer); ok {
+		return i.variantSameBatch(batchSamer, images)
+	}
+	return pairwiseSameBatch(i, images)
+}
+
+func (i *Invariant) hashSameBatch(hasher dihedralHasher, images <-chan *IDImage) <-chan *Pair {
+	res := make(chan *Pair, 1)
+	go func() {
+		defer close(res)
+		ids := []interface{}{}
+		hashes := [][]bool{}
+		for image := range images {
+			hash := hasher.Hash(image.Image)
+			variants := i.hashVariants(hasher, image.Image, hash)
+			for j, hash1 := range hashes {
+				for _, variant := range variants {
+					if hasher.matchRatio(hash1, variant) >= hasher.threshold() {
+						res <- &Pair{ids[j], image.ID}
+						break
+					}
+				}
+			}
+			ids = append(ids, image.ID)
+			hashes = append(hashes, hash)
+		}
+	}()
+	return res
+}
+
+// invariantVariant identifies an image which is passed to
+// a wrapped BatchSamer.
+// Every image is passed in its original form, and also
+// under each non-identity transform.
+type invariantVariant struct {
+	Index    int
+	Dihedral Dihedral
+}
+
+func (i *Invariant) variantSameBatch(batchSamer BatchSamer, images <-chan *IDImage) <-chan *Pair {
+	// The IDs of an image are recorded before its
+	// variants are sent, so they are always available
+	// when a pair is found.
+	var idLock sync.Mutex
+	var ids []interface{}
+
+	useIdentity := false
+	for _, d := range i.dihedrals() {
+		if d == Identity {
+			useIdentity = true
+		}
+	}
+
+	variants := make(chan *IDImage, 1)
+	go func() {
+		defer close(variants)
+		for image := range images {
+			idLock.Lock()
+			index := len(ids)
+			ids = append(ids, image.ID)
+			idLock.Unlock()
+			variants <- &IDImage{
+				Image: image.Image,
+				ID:    invariantVariant{Index: index, Dihedral: Identity},
+			}
+			for _, d := range i.dihedrals() {
+				if d != Identity {
+					variants <- &IDImage{
+						Image: d.Apply(image.Image),
+						ID:    invariantVariant{Index: index, Dihedral: d},
+					}
+				}
+			}
+		}
+	}()
+
+	res := make(chan *Pair, 1)
+	go func() {
+		defer close(res)
+		seen := map[[2]int]bool{}
+		for pair := range batchSamer.SameBatch(variants) {
+			variant1 := pair[0].(invariantVariant)
+			variant2 := pair[1].(invariantVariant)
+
+			// Like Same, only one image of each pair may be
+			// transformed.
+			if variant1.Dihedral != Identity && variant2.Dihedral != Identity {
+				continue
+			} else if variant1.Dihedral == Identity && variant2.Dihedral == Identity &&
+				!useIdentity {
+				continue
+			}
+
+			idx1, idx2 := variant1.Index, variant2.Index
+			if idx1 > idx2 {
+				idx1, idx2 = idx2, idx1
+			}
+			key := [2]int{idx1, idx2}
+			if idx1 == idx2 || seen[key] {
+				continue
+			}
+			seen[key] = true
+			idLock.Lock()
+			id1, id2 := ids[idx1], ids[idx2]
+			idLock.Unlock()
+			res <- &Pair{id1, id2}
+		}
+	}()
+	return res
+}
+
+func (i *Invariant) hashVariants(hasher dihedralHasher, img image.Image, hash []bool) [][]bool {
+	var res [][]bool
+	for _, d := range i.dihedrals() {
+		variant := hasher.transformHash(hash, d)
+		if variant == nil {
+			variant = hasher.Hash(d.Apply(img))
+		}
+		res = append(res, variant)
+	}
+	return res
+}
+
+func (i *Invariant) dihedrals() []Dihedral {
+	if i.Dihedrals == nil {
+		return Dihedrals
+	}
+	return i.Dihedrals
+}
+
+// transformGridBits rearranges the bits of a square grid,
+// stored in row-major order, to match a transformed
+// image.
+func transformGridBits(bits []bool, size int, d Dihedral) []bool {
+	res := make([]bool, len(bits))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			newX, newY := d.Point(float64(x)+0.5, float64(y)+0.5, float64(size), float64(size))
+			res[int(newY)*size+int(newX)] = bits[y*size+x]
+		}
+	}
+	return res
+}
+
+// squareSide finds the side length of a square grid with
+// the given number of cells.
+func squareSide(cells int) int {
+	size := 0
+	for size*size < cells {
+		size++
+	}
+	return size
+}
@@ -0,0 +1,146 @@
+package samepic
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"math"
+)
+
+const (
+	jpegQualityRegionSize = 256
+	jpegQualityStep       = 5
+	jpegQualityMaxRatio   = 0.1
+)
+
+// EstimateJPEGQuality estimates the quality with which an
+// image was last saved as a JPEG, or returns 0 if the
+// image shows no sign of JPEG compression.
+//
+// This uses "JPEG ghosts": re-compressing an image at its
+// original quality changes it very little compared to
+// slightly lower qualities.
+// Ghosts only survive if the image has not been scaled
+// or cropped since it was compressed.
+func EstimateJPEGQuality(img image.Image) int {
+	// Lossless wrappers are removed to get the pixels as
+	// they were compressed.
+	for {
+		if oriented, ok := img.(*OrientedImage); ok {
+			img = oriented.Image
+		} else if transformed, ok := img.(*dihedralImage); ok {
+			img = transformed.Image
+		} else {
+			break
+		}
+	}
+
+	// Compression works on blocks aligned to the image's
+	// origin, so a region at the origin is enough.
+	b := img.Bounds()
+	region := image.Rect(0, 0, jpegQualityRegionSize, jpegQualityRegionSize).Add(b.Min).Intersect(b)
+	if region.Dx() < 8 || region.Dy() < 8 {
+		return 0
+	}
+
+	// Converting a decoded JPEG to another color model would
+	// hide its ghosts, so the region is cropped in place
+	// when possible.
+	if subImager, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		img = subImager.SubImage(region)
+	} else {
+		img = copyJPEGRegion(img, region)
+	}
+
+	differences := map[int]float64{}
+	difference := func(quality int) float64 {
+		if d, ok := differences[quality]; ok {
+			return d
+		}
+		d := jpegDifference(img, quality)
+		differences[quality] = d
+		return d
+	}
+
+	// Find the sharpest drop in the difference between
+	// the image and its re-compressed copy.
+	bestQuality := 0
+	bestRatio := jpegQualityMaxRatio
+	for quality := jpegQualityStep * 2; quality < 100; quality += jpegQualityStep {
+		prev := difference(quality - jpegQualityStep)
+		if prev == 0 {
+			continue
+		}
+		if ratio := difference(quality) / prev; ratio < bestRatio {
+			bestQuality, bestRatio = quality, ratio
+		}
+	}
+	if bestQuality == 0 {
+		return 0
+	}
+
+	// Refine the estimate between the coarse steps.
+	res := bestQuality
+	for quality := bestQuality - jpegQualityStep + 1; quality < bestQuality+jpegQualityStep &&
+		quality <= 100; quality++ {
+		if difference(quality) < difference(res) {
+			res = quality
+		}
+	}
+	return res
+}
+
+// copyJPEGRegion copies a region of an image, keeping the
+// exact YCbCr values of decoded JPEGs.
+func copyJPEGRegion(img image.Image, region image.Rectangle) image.Image {
+	if img.ColorModel() != color.YCbCrModel {
+		res := image.NewRGBA(region)
+		draw.Draw(res, region, img, region.Min, draw.Src)
+		return res
+	}
+	res := image.NewYCbCr(region, image.YCbCrSubsampleRatio444)
+	for y := region.Min.Y; y < region.Max.Y; y++ {
+		for x := region.Min.X; x < region.Max.X; x++ {
+			c := color.YCbCrModel.Convert(img.At(x, y)).(color.YCbCr)
+			res.Y[res.YOffset(x, y)] = c.Y
+			res.Cb[res.COffset(x, y)] = c.Cb
+			res.Cr[res.COffset(x, y)] = c.Cr
+		}
+	}
+	return res
+}
+
+// jpegDifference computes the mean squared difference
+// between an image and a JPEG-compressed copy of it.
+func jpegDifference(img image.Image, quality int) float64 {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return math.Inf(1)
+	}
+	compressed, err := jpeg.Decode(&buf)
+	if err != nil {
+		return math.Inf(1)
+	}
+
+	b := img.Bounds()
+	cb := compressed.Bounds()
+	var sum float64
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			r1, g1, b1, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			r2, g2, b2, _ := compressed.At(cb.Min.X+x, cb.Min.Y+y).RGBA()
+			for _, diff := range []float64{
+				float64(r1) - float64(r2),
+				float64(g1) - float64(g2),
+				float64(b1) - float64(b2),
+			} {
+				sum += (diff / 0xffff) * (diff / 0xffff)
+			}
+		}
+	}
+	return sum / float64(b.Dx()*b.Dy()*3)
+}
@@ -0,0 +1,534 @@
+package samepic
+
+import (
+	"image"
+	"math"
+	"math/bits"
+	"math/rand"
+	"sort"
+)
+
+const (
+	DefaultKeypointMaxSize        = 320
+	DefaultKeypointMaxKeypoints   = 500
+	DefaultKeypointFASTThreshold  = 0.05
+	DefaultKeypointMaxHamming     = 64
+	DefaultKeypointRatio          = 0.8
+	DefaultKeypointIterations     = 500
+	DefaultKeypointInlierDistance = 3
+	DefaultKeypointMinInliers     = 10
+)
+
+const (
+	keypointPatchRadius     = 15
+	keypointBorder          = 22
+	keypointHarrisRadius    = 3
+	keypointHarrisK         = 0.04
+	keypointSuppressRadius  = 1
+	keypointBlurRadius      = 2
+	keypointDescriptorWords = 4
+	keypointDescriptorBits  = keypointDescriptorWords * 64
+	keypointPatternSeed     = 1337
+	keypointRANSACSeed      = 1
+	keypointPyramidLevels   = 4
+	keypointPyramidScale    = 1.3
+	keypointFASTCircleSize  = 16
+	keypointMinFASTArc      = 9
+)
+
+// fastCircle is the Bresenham circle of radius 3 used by
+// the FAST corner detector.
+var fastCircle = [keypointFASTCircleSize][2]int{
+	{0, -3}, {1, -3}, {2, -2}, {3, -1}, {3, 0}, {3, 1}, {2, 2}, {1, 3},
+	{0, 3}, {-1, 3}, {-2, 2}, {-3, 1}, {-3, 0}, {-3, -1}, {-2, -2}, {-1, -3},
+}
+
+// briefPattern stores the pairs of points (relative to
+// a keypoint) compared by each bit of a descriptor.
+var briefPattern = generateBRIEFPattern()
+
+// KeypointSamer compares images by matching local
+// features, which allows it to detect heavy crops and
+// partial occlusion that defeat global descriptors.
+//
+// Keypoints are found with the FAST corner detector and
+// ranked by their Harris corner response.
+// Each keypoint is described by an ORB-style binary
+// descriptor, which is rotated according to the
+// keypoint's intensity centroid.
+// Descriptors are matched by brute force, and matches
+// are verified by using RANSAC to fit a homography.
+type KeypointSamer struct {
+	// MaxSize is the maximum length of the long edge of an
+	// image when detecting keypoints.
+	// Larger images are scaled down.
+	//
+	// If this is 0, DefaultKeypointMaxSize is used.
+	MaxSize int
+
+	// MaxKeypoints is the maximum number of keypoints
+	// detected in each image.
+	//
+	// If this is 0, DefaultKeypointMaxKeypoints is used.
+	MaxKeypoints int
+
+	// FASTThreshold is the minimum brightness difference
+	// (between 0 and 1) between a corner and the pixels
+	// around it.
+	//
+	// If this is 0, DefaultKeypointFASTThreshold is used.
+	FASTThreshold float64
+
+	// MaxHamming is the maximum Hamming distance between
+	// two matching descriptors, out of 256 bits.
+	//
+	// If this is 0, DefaultKeypointMaxHamming is used.
+	MaxHamming int
+
+	// Ratio is the maximum ratio between the distances to
+	// the best and second-best descriptors for a match to
+	// be accepted.
+	//
+	// If this is 0, DefaultKeypointRatio is used.
+	Ratio float64
+
+	// Iterations is the number of RANSAC iterations.
+	//
+	// If this is 0, DefaultKeypointIterations is used.
+	Iterations int
+
+	// InlierDistance is the maximum reprojection error,
+	// in pixels of the scaled image, for a match to be
+	// considered an inlier.
+	//
+	// If this is 0, DefaultKeypointInlierDistance is used.
+	InlierDistance float64
+
+	// MinInliers is the minimum number of inliers for
+	// two images to be considered the same.
+	//
+	// If this is 0, DefaultKeypointMinInliers is used.
+	MinInliers int
+}
+
+// Same checks if enough keypoint matches agree on a
+// single homography between the images.
+func (k *KeypointSamer) Same(img1, img2 image.Image) bool {
+	return k.Inliers(img1, img2) >= k.minInliers()
+}
+
+// SameBatch finds pairs of near duplicates.
+func (k *KeypointSamer) SameBatch(images <-chan *IDImage) <-chan *Pair {
+	res := make(chan *Pair, 1)
+	go func() {
+		defer close(res)
+		ids := []interface{}{}
+		allFeatures := []*keypointFeatures{}
+		for image := range images {
+			features := k.features(image.Image)
+			for i, features1 := range allFeatures {
+				if _, inliers := k.match(features1, features); inliers >= k.minInliers() {
+					res <- &Pair{ids[i], image.ID}
+				}
+			}
+			ids = append(ids, image.ID)
+			allFeatures = append(allFeatures, features)
+		}
+	}()
+	return res
+}
+
+// Inliers computes the number of keypoint matches which
+// agree with the best homography between the images.
+// This serves as a similarity score, with larger values
+// indicating more confident matches.
+func (k *KeypointSamer) Inliers(img1, img2 image.Image) int {
+	_, inliers := k.match(k.features(img1), k.features(img2))
+	return inliers
+}
+
+// keypointFeatures stores the keypoints and descriptors
+// of an image.
+type keypointFeatures struct {
+	// Points are in the coordinates of the scaled image,
+	// regardless of the pyramid level they came from.
+	Points      [][2]float64
+	Descriptors [][keypointDescriptorWords]uint64
+
+	// Scale converts from original image coordinates
+	// (relative to the image's bounds) to scaled image
+	// coordinates.
+	Scale float64
+}
+
+// homography converts a homography between the scaled
+// images to one between the original images.
+func (k *keypointFeatures) homography(scaled homography, other *keypointFeatures) homography {
+	toScaled := homography{k.Scale, 0, 0, 0, k.Scale, 0, 0, 0, 1}
+	fromScaled := homography{1 / other.Scale, 0, 0, 0, 1 / other.Scale, 0, 0, 0, 1}
+	return fromScaled.Mul(scaled).Mul(toScaled)
+}
+
+func (k *KeypointSamer) features(img image.Image) *keypointFeatures {
+	maxSize := k.MaxSize
+	if maxSize == 0 {
+		maxSize = DefaultKeypointMaxSize
+	}
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	scale := math.Min(1, float64(maxSize)/float64(maxInt(width, height)))
+	width = maxInt(1, int(float64(width)*scale+0.5))
+	height = maxInt(1, int(float64(height)*scale+0.5))
+	pixels := newGrayMatrix(img, width, height)
+
+	// Detect keypoints in an image pyramid so that features
+	// can be matched across changes in scale.
+	res := &keypointFeatures{Scale: scale}
+	levelScale := 1.0
+	for level := 0; level < keypointPyramidLevels; level++ {
+		if level > 0 {
+			levelScale /= keypointPyramidScale
+			levelWidth := int(float64(width)*levelScale + 0.5)
+			levelHeight := int(float64(height)*levelScale + 0.5)
+			if levelWidth <= keypointBorder*2 || levelHeight <= keypointBorder*2 {
+				break
+			}
+			pixels = pixels.Resize(levelWidth, levelHeight)
+		}
+		smoothed := pixels.BoxBlur(keypointBlurRadius)
+		for _, point := range k.detect(pixels, level) {
+			angle := intensityAngle(pixels, point[0], point[1])
+			res.Points = append(res.Points, [2]float64{
+				(float64(point[0])+0.5)/levelScale - 0.5,
+				(float64(point[1])+0.5)/levelScale - 0.5,
+			})
+			res.Descriptors = append(res.Descriptors,
+				briefDescriptor(smoothed, point[0], point[1], angle))
+		}
+	}
+	return res
+}
+
+// detect finds the strongest FAST corners in one level of
+// the image pyramid, ranked by Harris response after
+// non-maximum suppression.
+func (k *KeypointSamer) detect(pixels *grayMatrix, level int) [][2]int {
+	threshold := k.FASTThreshold
+	if threshold == 0 {
+		threshold = DefaultKeypointFASTThreshold
+	}
+	maxKeypoints := k.MaxKeypoints
+	if maxKeypoints == 0 {
+		maxKeypoints = DefaultKeypointMaxKeypoints
+	}
+
+	// Split the keypoints between levels in proportion to
+	// their areas.
+	var totalArea float64
+	for i := 0; i < keypointPyramidLevels; i++ {
+		totalArea += math.Pow(keypointPyramidScale, -2*float64(i))
+	}
+	levelArea := math.Pow(keypointPyramidScale, -2*float64(level))
+	maxKeypoints = maxInt(1, int(float64(maxKeypoints)*levelArea/totalArea+0.5))
+
+	scores := make([]float64, len(pixels.Pix))
+	for y := keypointBorder; y < pixels.Height-keypointBorder; y++ {
+		for x := keypointBorder; x < pixels.Width-keypointBorder; x++ {
+			if isFASTCorner(pixels, x, y, threshold) {
+				scores[y*pixels.Width+x] = harrisResponse(pixels, x, y)
+			}
+		}
+	}
+
+	type candidate struct {
+		point [2]int
+		score float64
+	}
+	var candidates []candidate
+	const r = keypointSuppressRadius
+	for y := keypointBorder; y < pixels.Height-keypointBorder; y++ {
+		for x := keypointBorder; x < pixels.Width-keypointBorder; x++ {
+			score := scores[y*pixels.Width+x]
+			if score <= 0 {
+				continue
+			}
+			isMax := true
+			for ny := y - r; ny <= y+r && isMax; ny++ {
+				for nx := x - r; nx <= x+r; nx++ {
+					if (nx != x || ny != y) && scores[ny*pixels.Width+nx] > score {
+						isMax = false
+						break
+					}
+				}
+			}
+			if isMax {
+				candidates = append(candidates, candidate{[2]int{x, y}, score})
+			}
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].score > candidates[j].score
+	})
+	if len(candidates) > maxKeypoints {
+		candidates = candidates[:maxKeypoints]
+	}
+	res := make([][2]int, len(candidates))
+	for i, c := range candidates {
+		res[i] = c.point
+	}
+	return res
+}
+
+// match finds the homography (between the original
+// images) best supported by descriptor matches, along
+// with its number of inliers.
+func (k *KeypointSamer) match(f1, f2 *keypointFeatures) (homography, int) {
+	maxHamming := k.MaxHamming
+	if maxHamming == 0 {
+		maxHamming = DefaultKeypointMaxHamming
+	}
+	ratio := k.Ratio
+	if ratio == 0 {
+		ratio = DefaultKeypointRatio
+	}
+
+	// Only keep matches which are mutual nearest neighbors,
+	// so that no keypoint is used twice.
+	matches1 := nearestDescriptors(f1.Descriptors, f2.Descriptors, maxHamming, ratio)
+	matches2 := nearestDescriptors(f2.Descriptors, f1.Descriptors, maxHamming, ratio)
+	var src, dst [][2]float64
+	for i, j := range matches1 {
+		if j >= 0 && matches2[j] == i {
+			src = append(src, f1.Points[i])
+			dst = append(dst, f2.Points[j])
+		}
+	}
+
+	scaled, inliers := k.ransac(src, dst)
+	if inliers == 0 {
+		return homography{}, 0
+	}
+	return f1.homography(scaled, f2), inliers
+}
+
+// ransac robustly fits a homography to point matches.
+func (k *KeypointSamer) ransac(src, dst [][2]float64) (homography, int) {
+	if len(src) < 4 {
+		return homography{}, 0
+	}
+	iterations := k.Iterations
+	if iterations == 0 {
+		iterations = DefaultKeypointIterations
+	}
+	maxDist := k.InlierDistance
+	if maxDist == 0 {
+		maxDist = DefaultKeypointInlierDistance
+	}
+
+	// Use a fixed seed so that comparisons are repeatable.
+	gen := rand.New(rand.NewSource(keypointRANSACSeed))
+
+	var best homography
+	var bestInliers []int
+	for i := 0; i < iterations; i++ {
+		sample := gen.Perm(len(src))[:4]
+		var sampleSrc, sampleDst [][2]float64
+		for _, idx := range sample {
+			sampleSrc = append(sampleSrc, src[idx])
+			sampleDst = append(sampleDst, dst[idx])
+		}
+		h, ok := fitHomography(sampleSrc, sampleDst)
+		if !ok {
+			continue
+		}
+		if inliers := homographyInliers(h, src, dst, maxDist); len(inliers) > len(bestInliers) {
+			best, bestInliers = h, inliers
+		}
+	}
+	if len(bestInliers) < 4 {
+		return homography{}, 0
+	}
+
+	// Refine the model using all of its inliers.
+	var inlierSrc, inlierDst [][2]float64
+	for _, idx := range bestInliers {
+		inlierSrc = append(inlierSrc, src[idx])
+		inlierDst = append(inlierDst, dst[idx])
+	}
+	if refined, ok := fitHomography(inlierSrc, inlierDst); ok {
+		if inliers := homographyInliers(refined, src, dst, maxDist); len(inliers) >= len(bestInliers) {
+			best, bestInliers = refined, inliers
+		}
+	}
+	return best, len(bestInliers)
+}
+
+func homographyInliers(h homography, src, dst [][2]float64, maxDist float64) []int {
+	var res []int
+	for i, p := range src {
+		x, y := h.Apply(p[0], p[1])
+		if math.Hypot(x-dst[i][0], y-dst[i][1]) <= maxDist {
+			res = append(res, i)
+		}
+	}
+	return res
+}
+
+func (k *KeypointSamer) minInliers() int {
+	if k.MinInliers == 0 {
+		return DefaultKeypointMinInliers
+	}
+	return k.MinInliers
+}
+
+// isFASTCorner checks if there is a contiguous arc of
+// pixels on the circle around (x, y) which are all
+// brighter or all darker than the center by the
+// threshold.
+func isFASTCorner(pixels *grayMatrix, x, y int, threshold float64) bool {
+	center := pixels.At(x, y)
+	var states [keypointFASTCircleSize]int
+	for i, offset := range fastCircle {
+		value := pixels.At(x+offset[0], y+offset[1])
+		if value > center+threshold {
+			states[i] = 1
+		} else if value < center-threshold {
+			states[i] = -1
+		}
+	}
+
+	// Any long enough arc contains at least two of the
+	// four compass points, which allows a quick rejection.
+	for _, state := range []int{1, -1} {
+		var compass int
+		for i := 0; i < keypointFASTCircleSize; i += 4 {
+			if states[i] == state {
+				compass++
+			}
+		}
+		if compass < 2 {
+			continue
+		}
+		run := 0
+		for i := 0; i < keypointFASTCircleSize*2; i++ {
+			if states[i%keypointFASTCircleSize] == state {
+				run++
+				if run >= keypointMinFASTArc {
+					return true
+				}
+			} else {
+				run = 0
+			}
+		}
+	}
+	return false
+}
+
+// harrisResponse computes the Harris corner measure in a
+// window around a point.
+func harrisResponse(pixels *grayMatrix, x, y int) float64 {
+	var xx, yy, xy float64
+	for wy := y - keypointHarrisRadius; wy <= y+keypointHarrisRadius; wy++ {
+		for wx := x - keypointHarrisRadius; wx <= x+keypointHarrisRadius; wx++ {
+			dx := (pixels.At(wx+1, wy) - pixels.At(wx-1, wy)) / 2
+			dy := (pixels.At(wx, wy+1) - pixels.At(wx, wy-1)) / 2
+			xx += dx * dx
+			yy += dy * dy
+			xy += dx * dy
+		}
+	}
+	trace := xx + yy
+	return xx*yy - xy*xy - keypointHarrisK*trace*trace
+}
+
+// intensityAngle computes the orientation of a keypoint
+// as the direction from the keypoint to the intensity
+// centroid of the circular patch around it.
+func intensityAngle(pixels *grayMatrix, x, y int) float64 {
+	var m01, m10 float64
+	for dy := -keypointPatchRadius; dy <= keypointPatchRadius; dy++ {
+		for dx := -keypointPatchRadius; dx <= keypointPatchRadius; dx++ {
+			if dx*dx+dy*dy > keypointPatchRadius*keypointPatchRadius {
+				continue
+			}
+			value := pixels.At(x+dx, y+dy)
+			m10 += float64(dx) * value
+			m01 += float64(dy) * value
+		}
+	}
+	return math.Atan2(m01, m10)
+}
+
+// briefDescriptor computes a binary descriptor by
+// comparing pairs of pixels from briefPattern, rotated
+// by the keypoint's angle.
+func briefDescriptor(pixels *grayMatrix, x, y int, angle float64) [keypointDescriptorWords]uint64 {
+	cos, sin := math.Cos(angle), math.Sin(angle)
+	sample := func(p [2]float64) float64 {
+		rx := int(math.Floor(cos*p[0] - sin*p[1] + 0.5))
+		ry := int(math.Floor(sin*p[0] + cos*p[1] + 0.5))
+		return pixels.At(x+rx, y+ry)
+	}
+	var res [keypointDescriptorWords]uint64
+	for i, pair := range briefPattern {
+		if sample(pair[0]) < sample(pair[1]) {
+			res[i/64] |= 1 << uint(i%64)
+		}
+	}
+	return res
+}
+
+// generateBRIEFPattern creates a fixed, pseudo-random
+// set of point pairs drawn from an isotropic Gaussian
+// and clipped to the patch.
+func generateBRIEFPattern() [keypointDescriptorBits][2][2]float64 {
+	gen := rand.New(rand.NewSource(keypointPatternSeed))
+	stddev := float64(keypointPatchRadius*2+1) / 5
+	point := func() [2]float64 {
+		var res [2]float64
+		for i := range res {
+			res[i] = math.Max(-keypointPatchRadius,
+				math.Min(keypointPatchRadius, gen.NormFloat64()*stddev))
+		}
+		return res
+	}
+	var res [keypointDescriptorBits][2][2]float64
+	for i := range res {
+		res[i] = [2][2]float64{point(), point()}
+	}
+	return res
+}
+
+// nearestDescriptors finds the index of the closest
+// descriptor in descs2 for each descriptor in descs1, or
+// -1 if the closest descriptor is too far away or fails
+// the ratio test.
+func nearestDescriptors(descs1, descs2 [][keypointDescriptorWords]uint64, maxHamming int,
+	ratio float64) []int {
+	res := make([]int, len(descs1))
+	for i, desc1 := range descs1 {
+		best, secondBest := keypointDescriptorBits+1, keypointDescriptorBits+1
+		res[i] = -1
+		for j, desc2 := range descs2 {
+			dist := hammingDistance(desc1, desc2)
+			if dist < best {
+				secondBest = best
+				best, res[i] = dist, j
+			} else if dist < secondBest {
+				secondBest = dist
+			}
+		}
+		if best > maxHamming || float64(best) >= ratio*float64(secondBest) {
+			res[i] = -1
+		}
+	}
+	return res
+}
+
+func hammingDistance(d1, d2 [keypointDescriptorWords]uint64) int {
+	var res int
+	for i, word := range d1 {
+		res += bits.OnesCount64(word ^ d2[i])
+	}
+	return res
+}
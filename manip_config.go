@@ -0,0 +1,140 @@
+package samepic
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strconv"
+)
+
+// manipulatorConstructors maps manipulator names, as used
+// in JSON configurations, to functions which create the
+// corresponding zero-valued manipulators.
+var manipulatorConstructors = map[string]func() Manipulator{
+	"jpeg":        func() Manipulator { return &CompressJPEG{} },
+	"scale":       func() Manipulator { return &Scale{} },
+	"crop":        func() Manipulator { return &Crop{} },
+	"pad":         func() Manipulator { return &Pad{} },
+	"affine":      func() Manipulator { return &Affine{} },
+	"perspective": func() Manipulator { return &Perspective{} },
+	"reencode":    func() Manipulator { return &Reencode{} },
+	"cropedges":   func() Manipulator { return &CropEdges{} },
+	"limitsize":   func() Manipulator { return &LimitSize{} },
+	"flatten":     func() Manipulator { return &FlattenAlpha{} },
+}
+
+// A manipulatorValidator is a manipulator which can check
+// its parameters, so that invalid configurations are
+// reported when they are loaded rather than causing a
+// panic later.
+type manipulatorValidator interface {
+	validate() error
+}
+
+// manipulatorConfig is the JSON representation of a
+// manipulator.
+type manipulatorConfig struct {
+	Type string `json:"type"`
+
+	// Params is decoded directly into the manipulator's
+	// struct, so its keys are the manipulator's field
+	// names (e.g. "MinScale").
+	Params json.RawMessage `json:"params"`
+
+	// These are used for the "aggregate" type.
+	Manipulators  []*manipulatorConfig `json:"manipulators"`
+	Probabilities []float64            `json:"probabilities"`
+}
+
+// LoadManipulator reads a JSON manipulator configuration
+// from a file.
+// See ParseManipulator for the format.
+func LoadManipulator(path string) (Manipulator, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseManipulator(data)
+}
+
+// ParseManipulator decodes a JSON manipulator
+// configuration.
+//
+// A configuration is an object with a "type" key naming
+// the manipulator (jpeg, scale, crop, pad, affine,
+// perspective, reencode, cropedges, limitsize, flatten,
+// or aggregate).
+// A "params" object sets the manipulator's fields by
+// name, for example:
+//
+//	{"type": "scale", "params": {"MinScale": 0.5, "MaxScale": 1.5}}
+//
+// Unknown keys and invalid parameters are reported as
+// errors.
+//
+// An aggregate has "manipulators" and "probabilities"
+// lists in place of "params", and aggregates may be
+// nested.
+// DefaultManipulator could be written as:
+//
+//	{
+//	  "type": "aggregate",
+//	  "manipulators": [
+//	    {"type": "scale", "params": {"MinScale": 0.5, "MaxScale": 1.5}},
+//	    {"type": "crop", "params": {"MinMajorKeep": 0.5, "MinMinorKeep": 0.8}},
+//	    {"type": "jpeg"}
+//	  ],
+//	  "probabilities": [0.5, 0.5, 0.5]
+//	}
+func ParseManipulator(data []byte) (Manipulator, error) {
+	var config manipulatorConfig
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, err
+	}
+	return config.Manipulator()
+}
+
+// Manipulator creates the manipulator described by the
+// configuration.
+func (m *manipulatorConfig) Manipulator() (Manipulator, error) {
+	if m.Type == "aggregate" {
+		if len(m.Manipulators) != len(m.Probabilities) {
+			return nil, errors.New("aggregate has " + strconv.Itoa(len(m.Manipulators)) +
+				" manipulators but " + strconv.Itoa(len(m.Probabilities)) + " probabilities")
+		}
+		res := &AggregateManipulator{Probabilities: m.Probabilities}
+		for _, child := range m.Manipulators {
+			if child == nil {
+				return nil, errors.New("aggregate has a null manipulator")
+			}
+			manip, err := child.Manipulator()
+			if err != nil {
+				return nil, err
+			}
+			res.Manipulators = append(res.Manipulators, manip)
+		}
+		return res, nil
+	}
+
+	constructor, ok := manipulatorConstructors[m.Type]
+	if !ok {
+		return nil, errors.New("unknown manipulator: " + m.Type)
+	}
+	res := constructor()
+	if len(m.Params) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(m.Params))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(res); err != nil {
+			return nil, errors.New("manipulator " + m.Type + ": " + err.Error())
+		}
+	}
+	if v, ok := res.(manipulatorValidator); ok {
+		if err := v.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
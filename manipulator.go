@@ -2,10 +2,14 @@ package samepic
 
 import (
 	"bytes"
+	"errors"
 	"image"
+	"image/color"
+	"image/draw"
 	"image/jpeg"
 	"math"
 	"math/rand"
+	"strconv"
 
 	"github.com/nfnt/resize"
 )
@@ -72,6 +76,15 @@ func (c *CompressJPEG) Manipulate(img image.Image) image.Image {
 	return res
 }
 
+func (c *CompressJPEG) validate() error {
+	if c.MinQuality < 0 || c.MinQuality > 100 || c.MaxQuality < 0 || c.MaxQuality > 100 {
+		return errors.New("jpeg qualities must be between 1 and 100")
+	} else if c.MaxQuality != 0 && c.MinQuality > c.MaxQuality {
+		return errors.New("jpeg MinQuality exceeds MaxQuality")
+	}
+	return nil
+}
+
 // A Scale manipulates images by resizing them.
 type Scale struct {
 	// Interpolations stores the allowed interpolation
@@ -106,6 +119,30 @@ func (s *Scale) Manipulate(img image.Image) image.Image {
 	return resize.Resize(newWidth, 0, img, interp)
 }
 
+func (s *Scale) validate() error {
+	if s.Interpolations != nil && len(s.Interpolations) == 0 {
+		return errors.New("scale Interpolations is empty")
+	}
+	for _, interp := range s.Interpolations {
+		if err := validateInterpolation(interp); err != nil {
+			return err
+		}
+	}
+	if s.MinScale < 0 || s.MaxScale < 0 {
+		return errors.New("scale ratios must be positive")
+	} else if s.MinScale > s.MaxScale {
+		return errors.New("scale MinScale exceeds MaxScale")
+	}
+	return nil
+}
+
+func validateInterpolation(interp resize.InterpolationFunction) error {
+	if interp < resize.NearestNeighbor || interp > resize.Lanczos3 {
+		return errors.New("unknown interpolation: " + strconv.Itoa(int(interp)))
+	}
+	return nil
+}
+
 // A Crop manipulates images by cropping out a random
 // region from them.
 type Crop struct {
@@ -157,6 +194,13 @@ func (c *Crop) Manipulate(img image.Image) image.Image {
 	}
 }
 
+func (c *Crop) validate() error {
+	if c.MinMajorKeep < 0 || c.MinMajorKeep > 1 || c.MinMinorKeep < 0 || c.MinMinorKeep > 1 {
+		return errors.New("crop keep fractions must be between 0 and 1")
+	}
+	return nil
+}
+
 func cropImage(img image.Image, x, y, width, height int) image.Image {
 	newImage := image.NewRGBA(image.Rect(0, 0, width, height))
 	for destY := 0; destY < height; destY++ {
@@ -167,6 +211,283 @@ func cropImage(img image.Image, x, y, width, height int) image.Image {
 	return newImage
 }
 
+const (
+	DefaultPadMinAspect = 0.5
+	DefaultPadMaxAspect = 2.0
+)
+
+// PadFill is a technique for filling the background
+// added by a Pad.
+type PadFill int
+
+const (
+	// SolidPad fills the background with a solid color.
+	SolidPad PadFill = iota
+
+	// MirrorPad fills the background by reflecting the
+	// image across its edges.
+	MirrorPad
+
+	// BlurPad fills the background with a blurred copy
+	// of the image, scaled up to cover the new bounds.
+	BlurPad
+)
+
+// A Pad manipulates images by padding them to a random
+// aspect ratio, as is done when letterboxing an image or
+// adding borders to it.
+// It is essentially the inverse of Crop.
+type Pad struct {
+	// Fills stores the allowed background fills.
+	// If this is nil, all fills are allowed.
+	Fills []PadFill
+
+	// Colors stores the allowed colors for SolidPad.
+	// If this is nil, black and white are used.
+	Colors []color.RGBA
+
+	// These parameters determine the range of aspect
+	// ratios (width divided by height) to pad to.
+	// An image is padded horizontally if the chosen
+	// aspect ratio is wider than its own, and vertically
+	// otherwise.
+	//
+	// If MinAspect is 0, DefaultPadMinAspect is used.
+	// If MaxAspect is 0, DefaultPadMaxAspect is used.
+	// Manipulate panics if the range is negative or
+	// inverted.
+	MinAspect float64
+	MaxAspect float64
+}
+
+// Manipulate pads the image to a random aspect ratio,
+// placing the original image at a random offset within
+// the new bounds.
+func (p *Pad) Manipulate(img image.Image) image.Image {
+	fills := p.Fills
+	if fills == nil {
+		fills = []PadFill{SolidPad, MirrorPad, BlurPad}
+	}
+	fill := fills[rand.Intn(len(fills))]
+
+	minAspect, maxAspect, err := p.aspectRange()
+	if err != nil {
+		panic(err)
+	}
+
+	width := img.Bounds().Dx()
+	height := img.Bounds().Dy()
+	aspect := rand.Float64()*(maxAspect-minAspect) + minAspect
+	newWidth, newHeight := width, height
+	if aspect > float64(width)/float64(height) {
+		newWidth = int(float64(height)*aspect + 0.5)
+	} else {
+		newHeight = int(float64(width)/aspect + 0.5)
+	}
+	xOffset := rand.Intn(newWidth - width + 1)
+	yOffset := rand.Intn(newHeight - height + 1)
+
+	var background image.Image
+	switch fill {
+	case SolidPad:
+		colors := p.Colors
+		if colors == nil {
+			colors = []color.RGBA{
+				{A: 0xff},
+				{R: 0xff, G: 0xff, B: 0xff, A: 0xff},
+			}
+		}
+		background = image.NewUniform(colors[rand.Intn(len(colors))])
+	case MirrorPad:
+		background = &mirrorImage{
+			Image:   img,
+			xOffset: xOffset,
+			yOffset: yOffset,
+		}
+	case BlurPad:
+		background = blurredBackground(img, newWidth, newHeight)
+	}
+
+	newImage := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	draw.Draw(newImage, newImage.Bounds(), background, image.ZP, draw.Src)
+	draw.Draw(newImage, image.Rect(xOffset, yOffset, xOffset+width, yOffset+height),
+		img, img.Bounds().Min, draw.Src)
+	return newImage
+}
+
+func (p *Pad) validate() error {
+	if p.Fills != nil && len(p.Fills) == 0 {
+		return errors.New("pad Fills is empty")
+	}
+	for _, fill := range p.Fills {
+		if fill != SolidPad && fill != MirrorPad && fill != BlurPad {
+			return errors.New("unknown pad fill: " + strconv.Itoa(int(fill)))
+		}
+	}
+	if p.Colors != nil && len(p.Colors) == 0 {
+		return errors.New("pad Colors is empty")
+	}
+	_, _, err := p.aspectRange()
+	return err
+}
+
+func (p *Pad) aspectRange() (min, max float64, err error) {
+	min, max = p.MinAspect, p.MaxAspect
+	if min == 0 {
+		min = DefaultPadMinAspect
+	}
+	if max == 0 {
+		max = DefaultPadMaxAspect
+	}
+	if min < 0 || max < 0 {
+		return 0, 0, errors.New("pad aspect ratios must be positive")
+	} else if min > max {
+		return 0, 0, errors.New("pad MinAspect exceeds MaxAspect")
+	}
+	return
+}
+
+// mirrorImage extends an image infinitely in every
+// direction by reflecting it across its edges.
+// The image's origin is moved to (xOffset, yOffset).
+type mirrorImage struct {
+	image.Image
+
+	xOffset int
+	yOffset int
+}
+
+func (m *mirrorImage) Bounds() image.Rectangle {
+	return image.Rect(-1<<30, -1<<30, 1<<30, 1<<30)
+}
+
+func (m *mirrorImage) At(x, y int) color.Color {
+	b := m.Image.Bounds()
+	return m.Image.At(b.Min.X+mirrorCoord(x-m.xOffset, b.Dx()),
+		b.Min.Y+mirrorCoord(y-m.yOffset, b.Dy()))
+}
+
+// mirrorCoord reflects a coordinate into the range
+// [0, size).
+func mirrorCoord(c, size int) int {
+	period := size * 2
+	c %= period
+	if c < 0 {
+		c += period
+	}
+	if c >= size {
+		c = period - c - 1
+	}
+	return c
+}
+
+// blurredBackground scales an image to cover the given
+// dimensions and blurs it heavily.
+func blurredBackground(img image.Image, width, height int) image.Image {
+	scale := math.Max(float64(width)/float64(img.Bounds().Dx()),
+		float64(height)/float64(img.Bounds().Dy()))
+	coverWidth := int(float64(img.Bounds().Dx())*scale + 0.5)
+	coverHeight := int(float64(img.Bounds().Dy())*scale + 0.5)
+
+	// Blur by throwing away detail and then scaling back up.
+	const blurDivisor = 16
+	tiny := resize.Resize(uint(math.Max(1, float64(coverWidth/blurDivisor))),
+		uint(math.Max(1, float64(coverHeight/blurDivisor))), img, resize.Bilinear)
+	cover := resize.Resize(uint(coverWidth), uint(coverHeight), tiny, resize.Bilinear)
+
+	return cropImage(cover, cover.Bounds().Min.X+(coverWidth-width)/2,
+		cover.Bounds().Min.Y+(coverHeight-height)/2, width, height)
+}
+
+// A CropEdges manipulates images by cutting fixed
+// fractions off of their edges, as is done when cropping
+// the status bar out of a screenshot.
+type CropEdges struct {
+	// These parameters specify the fraction of the
+	// image's height (for Top and Bottom) or width (for
+	// Left and Right) to remove from each edge.
+	Top    float64
+	Bottom float64
+	Left   float64
+	Right  float64
+}
+
+// Manipulate crops the edges off of the image.
+func (c *CropEdges) Manipulate(img image.Image) image.Image {
+	width := float64(img.Bounds().Dx())
+	height := float64(img.Bounds().Dy())
+	left := int(width*c.Left + 0.5)
+	top := int(height*c.Top + 0.5)
+	newWidth := int(width*(1-c.Right)+0.5) - left
+	newHeight := int(height*(1-c.Bottom)+0.5) - top
+	if newWidth < 1 || newHeight < 1 {
+		return img
+	}
+	return cropImage(img, img.Bounds().Min.X+left, img.Bounds().Min.Y+top,
+		newWidth, newHeight)
+}
+
+func (c *CropEdges) validate() error {
+	for _, fraction := range []float64{c.Top, c.Bottom, c.Left, c.Right} {
+		if fraction < 0 || fraction >= 1 {
+			return errors.New("cropedges fractions must be between 0 and 1")
+		}
+	}
+	return nil
+}
+
+// A LimitSize manipulates images by shrinking them so
+// that their long edge fits within a maximum size, as
+// is done by most upload services.
+// Images which already fit are left unchanged.
+type LimitSize struct {
+	// MaxSize is the maximum length of the long edge.
+	MaxSize int
+
+	// Interpolation is the technique used to resize
+	// the image.
+	Interpolation resize.InterpolationFunction
+}
+
+// Manipulate shrinks the image if necessary.
+func (l *LimitSize) Manipulate(img image.Image) image.Image {
+	width := img.Bounds().Dx()
+	height := img.Bounds().Dy()
+	if width <= l.MaxSize && height <= l.MaxSize {
+		return img
+	}
+	if width >= height {
+		return resize.Resize(uint(l.MaxSize), 0, img, l.Interpolation)
+	}
+	return resize.Resize(0, uint(l.MaxSize), img, l.Interpolation)
+}
+
+func (l *LimitSize) validate() error {
+	if l.MaxSize <= 0 {
+		return errors.New("limitsize MaxSize must be positive")
+	}
+	return validateInterpolation(l.Interpolation)
+}
+
+// A FlattenAlpha manipulates images by compositing them
+// onto a solid background, removing transparency.
+type FlattenAlpha struct {
+	// Background is the color behind the image.
+	// Its alpha component is ignored, so the zero value
+	// is opaque black.
+	Background color.RGBA
+}
+
+// Manipulate flattens the image onto the background.
+func (f *FlattenAlpha) Manipulate(img image.Image) image.Image {
+	background := f.Background
+	background.A = 0xff
+	newImage := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(newImage, newImage.Bounds(), image.NewUniform(background), image.ZP, draw.Src)
+	draw.Draw(newImage, newImage.Bounds(), img, img.Bounds().Min, draw.Over)
+	return newImage
+}
+
 // An AggregateManipulator probabilistically applies
 // an assortment of Manipulators (in order) to images.
 type AggregateManipulator struct {
@@ -0,0 +1,285 @@
+package samepic
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultMaskedSamerGridSize    = 8
+	DefaultMaskedSamerAutoRatio   = 3
+	DefaultMaskedSamerMaxAutoArea = 0.25
+)
+
+const autoMaskScaleSize = 64
+
+// A MaskRect is a rectangle in normalized coordinates,
+// where (0, 0) is the top-left corner of an image and
+// (1, 1) is the bottom-right corner.
+type MaskRect struct {
+	MinX, MinY float64
+	MaxX, MaxY float64
+}
+
+// Rect converts the rectangle to pixel coordinates within
+// the bounds of an image.
+func (m MaskRect) Rect(bounds image.Rectangle) image.Rectangle {
+	w, h := float64(bounds.Dx()), float64(bounds.Dy())
+	return image.Rect(
+		bounds.Min.X+int(math.Floor(m.MinX*w)),
+		bounds.Min.Y+int(math.Floor(m.MinY*h)),
+		bounds.Min.X+int(math.Ceil(m.MaxX*w)),
+		bounds.Min.Y+int(math.Ceil(m.MaxY*h)),
+	).Intersect(bounds)
+}
+
+// A Mask is a set of regions to leave out of a
+// comparison, such as a watermark or a caption bar.
+type Mask []MaskRect
+
+// ParseMask parses a semicolon-separated list of
+// rectangles, each of which is given as
+// "minX,minY,maxX,maxY" in normalized coordinates.
+//
+// For example, "0.8,0.8,1,1;0,0.9,1,1" masks the
+// bottom-right corner and a bar along the bottom.
+func ParseMask(spec string) (Mask, error) {
+	var res Mask
+	for _, rectSpec := range strings.Split(spec, ";") {
+		rectSpec = strings.TrimSpace(rectSpec)
+		if rectSpec == "" {
+			continue
+		}
+		parts := strings.Split(rectSpec, ",")
+		if len(parts) != 4 {
+			return nil, errors.New("invalid mask rectangle: " + rectSpec)
+		}
+		var coords [4]float64
+		for i, part := range parts {
+			x, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+			if err != nil || x < 0 || x > 1 {
+				return nil, errors.New("invalid mask rectangle: " + rectSpec)
+			}
+			coords[i] = x
+		}
+		if coords[0] >= coords[2] || coords[1] >= coords[3] {
+			return nil, errors.New("invalid mask rectangle: " + rectSpec)
+		}
+		res = append(res, MaskRect{
+			MinX: coords[0],
+			MinY: coords[1],
+			MaxX: coords[2],
+			MaxY: coords[3],
+		})
+	}
+	return res, nil
+}
+
+// Apply fills the masked regions of an image with a
+// solid color.
+func (m Mask) Apply(img image.Image, fill color.Color) image.Image {
+	if len(m) == 0 {
+		return img
+	}
+	res := &maskedImage{Image: img, fill: fill}
+	for _, rect := range m {
+		res.rects = append(res.rects, rect.Rect(img.Bounds()))
+	}
+	return res
+}
+
+// maskedImage lazily fills regions of an image.
+type maskedImage struct {
+	image.Image
+
+	rects []image.Rectangle
+	fill  color.Color
+}
+
+func (m *maskedImage) ColorModel() color.Model {
+	return color.RGBA64Model
+}
+
+func (m *maskedImage) At(x, y int) color.Color {
+	p := image.Pt(x, y)
+	for _, rect := range m.rects {
+		if p.In(rect) {
+			return m.fill
+		}
+	}
+	return m.Image.At(x, y)
+}
+
+// MaskedSamer wraps a Samer, filling masked regions of
+// both images with the same solid color before comparing
+// them, so that watermarks and overlays are ignored.
+type MaskedSamer struct {
+	Samer Samer
+
+	// Mask is a fixed set of regions to leave out.
+	Mask Mask
+
+	// Fill is the color of the masked regions.
+	// If this is nil, mid gray is used.
+	Fill color.Color
+
+	// AutoMask, if true, also masks the grid cells where
+	// each pair of images differs the most.
+	// This only makes sense for images which are roughly
+	// aligned, and it prevents SameBatch from delegating
+	// to a wrapped BatchSamer.
+	AutoMask bool
+
+	// GridSize is the number of rows and columns of cells
+	// considered by AutoMask.
+	//
+	// If this is 0, DefaultMaskedSamerGridSize is used.
+	GridSize int
+
+	// AutoRatio is the minimum ratio between a cell's
+	// difference and the median cell difference for
+	// AutoMask to mask that cell.
+	//
+	// If this is 0, DefaultMaskedSamerAutoRatio is used.
+	AutoRatio float64
+
+	// MaxAutoArea is the maximum fraction of cells that
+	// AutoMask may mask.
+	//
+	// If this is 0, DefaultMaskedSamerMaxAutoArea is used.
+	MaxAutoArea float64
+}
+
+// Same masks the images and compares the results.
+func (m *MaskedSamer) Same(img1, img2 image.Image) bool {
+	mask := m.Mask
+	if m.AutoMask {
+		mask = append(append(Mask{}, mask...), m.DetectMask(img1, img2)...)
+	}
+	return m.Samer.Same(mask.Apply(img1, m.fill()), mask.Apply(img2, m.fill()))
+}
+
+// SameBatch finds pairs of near duplicates.
+//
+// If AutoMask is false and the wrapped Samer is a
+// BatchSamer, it is fed the masked images.
+// Otherwise, every pair of images is compared with Same.
+func (m *MaskedSamer) SameBatch(images <-chan *IDImage) <-chan *Pair {
+	if m.AutoMask {
+		return pairwiseSameBatch(m, images)
+	}
+	masked := make(chan *IDImage, 1)
+	go func() {
+		defer close(masked)
+		for image := range images {
+			masked <- &IDImage{Image: m.Mask.Apply(image.Image, m.fill()), ID: image.ID}
+		}
+	}()
+	if batchSamer, ok := m.Samer.(BatchSamer); ok {
+		return batchSamer.SameBatch(masked)
+	}
+	return pairwiseSameBatch(m.Samer, masked)
+}
+
+// DetectMask finds the grid cells where two images differ
+// much more than they do elsewhere.
+//
+// Regions already covered by the fixed Mask are not
+// considered.
+func (m *MaskedSamer) DetectMask(img1, img2 image.Image) Mask {
+	gridSize, autoRatio, maxArea := m.parameters()
+	fill := m.fill()
+	pixels1 := normalizedGrayPixels(m.Mask.Apply(img1, fill))
+	pixels2 := normalizedGrayPixels(m.Mask.Apply(img2, fill))
+
+	cellSize := float64(autoMaskScaleSize) / float64(gridSize)
+	diffs := make([]float64, gridSize*gridSize)
+	counts := make([]float64, gridSize*gridSize)
+	for y := 0; y < autoMaskScaleSize; y++ {
+		for x := 0; x < autoMaskScaleSize; x++ {
+			cell := int(float64(y)/cellSize)*gridSize + int(float64(x)/cellSize)
+			idx := y*autoMaskScaleSize + x
+			diffs[cell] += math.Abs(pixels1[idx] - pixels2[idx])
+			counts[cell]++
+		}
+	}
+	for i := range diffs {
+		diffs[i] /= counts[i]
+	}
+
+	cutoff := autoRatio * medianValue(diffs)
+	order := make([]int, len(diffs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return diffs[order[i]] > diffs[order[j]]
+	})
+	maxCells := int(maxArea * float64(len(diffs)))
+
+	var res Mask
+	for _, cell := range order[:maxCells] {
+		if diffs[cell] <= cutoff {
+			break
+		}
+		x, y := cell%gridSize, cell/gridSize
+		res = append(res, MaskRect{
+			MinX: float64(x) / float64(gridSize),
+			MinY: float64(y) / float64(gridSize),
+			MaxX: float64(x+1) / float64(gridSize),
+			MaxY: float64(y+1) / float64(gridSize),
+		})
+	}
+	return res
+}
+
+func (m *MaskedSamer) parameters() (gridSize int, autoRatio, maxArea float64) {
+	gridSize, autoRatio, maxArea = m.GridSize, m.AutoRatio, m.MaxAutoArea
+	if gridSize == 0 {
+		gridSize = DefaultMaskedSamerGridSize
+	}
+	if autoRatio == 0 {
+		autoRatio = DefaultMaskedSamerAutoRatio
+	}
+	if maxArea == 0 {
+		maxArea = DefaultMaskedSamerMaxAutoArea
+	}
+	return
+}
+
+func (m *MaskedSamer) fill() color.Color {
+	if m.Fill == nil {
+		return color.Gray{Y: 0x80}
+	}
+	return m.Fill
+}
+
+// normalizedGrayPixels scales an image down and
+// normalizes its brightness to have zero mean and unit
+// variance, so that global brightness and contrast
+// changes don't look like local differences.
+func normalizedGrayPixels(img image.Image) []float64 {
+	pixels := grayPixels(img, autoMaskScaleSize, autoMaskScaleSize)
+	var mean float64
+	for _, x := range pixels {
+		mean += x
+	}
+	mean /= float64(len(pixels))
+	var variance float64
+	for _, x := range pixels {
+		variance += (x - mean) * (x - mean)
+	}
+	stdDev := math.Sqrt(variance / float64(len(pixels)))
+	if stdDev == 0 {
+		stdDev = 1
+	}
+	for i, x := range pixels {
+		pixels[i] = (x - mean) / stdDev
+	}
+	return pixels
+}
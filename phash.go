@@ -0,0 +1,196 @@
+package samepic
+
+import (
+	"image"
+	"math"
+	"sort"
+)
+
+const (
+	DefaultPerceptualHashScaleSize = 32
+	DefaultPerceptualHashHashSize  = 8
+	DefaultPerceptualHashThreshold = 0.85
+)
+
+// PerceptualHash compares two images using the DCT-based
+// perceptual hash described on
+// http://www.hackerfactor.com/blog/index.php?/archives/432-Looks-Like-It.html.
+//
+// Unlike AverageHash, which compares pixels to their
+// mean, PerceptualHash compares low-frequency DCT
+// coefficients to their median, making it more robust to
+// compression and scaling.
+type PerceptualHash struct {
+	// ScaleSize is the size to which images are scaled
+	// before computing the DCT.
+	// For example, if this is 32, images are scaled to
+	// 32x32.
+	//
+	// If this is 0, DefaultPerceptualHashScaleSize is
+	// used.
+	ScaleSize int
+
+	// HashSize is the number of low-frequency
+	// coefficients kept along each axis of the DCT.
+	// The hash has HashSize*HashSize-1 bits, since the DC
+	// coefficient is left out.
+	// It is clamped to at least 2 (raising ScaleSize if
+	// necessary) and at most ScaleSize.
+	//
+	// If this is 0, DefaultPerceptualHashHashSize is used.
+	HashSize int
+
+	// Threshold is the minimum fraction of hash bits that
+	// must match for two images to be considered the same.
+	//
+	// If this is 0, DefaultPerceptualHashThreshold is used.
+	Threshold float64
+}
+
+// Same computes the hashes of two images and uses the
+// results to determine if the images are the same.
+func (p *PerceptualHash) Same(img1, img2 image.Image) bool {
+	hash1 := p.Hash(img1)
+	hash2 := p.Hash(img2)
+	return hashMatchRatio(hash1, hash2) >= p.threshold()
+}
+
+// SameBatch finds pairs of near duplicates.
+func (p *PerceptualHash) SameBatch(images <-chan *IDImage) <-chan *Pair {
+	return hashSameBatch(images, p.Hash, p.threshold())
+}
+
+// Hash creates the perceptual hash of an image.
+func (p *PerceptualHash) Hash(img image.Image) []bool {
+	scaleSize := p.ScaleSize
+	if scaleSize == 0 {
+		scaleSize = DefaultPerceptualHashScaleSize
+	}
+	hashSize := p.HashSize
+	if hashSize == 0 {
+		hashSize = DefaultPerceptualHashHashSize
+	}
+	if hashSize > scaleSize {
+		hashSize = scaleSize
+	}
+	if hashSize < 2 {
+		hashSize = 2
+		scaleSize = maxInt(scaleSize, hashSize)
+	}
+
+	coeffs := dct2D(grayPixels(img, scaleSize, scaleSize), scaleSize)
+	lowFreq := make([]float64, 0, hashSize*hashSize-1)
+	for y := 0; y < hashSize; y++ {
+		row := coeffs[y*scaleSize : y*scaleSize+hashSize]
+		if y == 0 {
+			// The DC coefficient is the mean brightness,
+			// which would always be above the median.
+			row = row[1:]
+		}
+		lowFreq = append(lowFreq, row...)
+	}
+
+	median := medianValue(lowFreq)
+	res := make([]bool, len(lowFreq))
+	for i, x := range lowFreq {
+		res[i] = x > median
+	}
+	return res
+}
+
+func (p *PerceptualHash) matchRatio(h1, h2 []bool) float64 {
+	return hashMatchRatio(h1, h2)
+}
+
+// transformHash rearranges the bits of a hash to get the
+// hash of a transformed image.
+//
+// Transposing an image transposes its DCT exactly.
+// Flipping an image negates the coefficients with odd
+// frequencies along the flipped axis, which is treated
+// as inverting their bits; this is approximate, since
+// the median is not exactly zero.
+func (p *PerceptualHash) transformHash(hash []bool, d Dihedral) []bool {
+	size := squareSide(len(hash) + 1)
+
+	// Find the axis of the original image along which
+	// each axis of the transformed image runs.
+	inv := d.Inverse()
+	x0, y0 := inv.Point(0, 0, 1, 1)
+	x1, y1 := inv.Point(1, 0, 1, 1)
+	x2, y2 := inv.Point(0, 1, 1, 1)
+	swapped := x1 == x0
+	flipX, flipY := x1 < x0, y2 < y0
+	if swapped {
+		flipX, flipY = y1 < y0, x2 < x0
+	}
+
+	res := make([]bool, len(hash))
+	for v := 0; v < size; v++ {
+		for u := 0; u < size; u++ {
+			if u == 0 && v == 0 {
+				continue
+			}
+			srcU, srcV := u, v
+			if swapped {
+				srcU, srcV = v, u
+			}
+			negated := (flipX && u%2 == 1) != (flipY && v%2 == 1)
+			res[v*size+u-1] = hash[srcV*size+srcU-1] != negated
+		}
+	}
+	return res
+}
+
+func (p *PerceptualHash) threshold() float64 {
+	if p.Threshold == 0 {
+		return DefaultPerceptualHashThreshold
+	}
+	return p.Threshold
+}
+
+// dct2D computes the two-dimensional type-II DCT of a
+// square, row-major matrix.
+func dct2D(pixels []float64, size int) []float64 {
+	cosines := make([]float64, size*size)
+	for freq := 0; freq < size; freq++ {
+		for i := 0; i < size; i++ {
+			cosines[freq*size+i] = math.Cos(math.Pi / float64(size) *
+				(float64(i) + 0.5) * float64(freq))
+		}
+	}
+
+	// The transform is separable, so we transform the rows
+	// and then the columns.
+	rows := make([]float64, size*size)
+	for y := 0; y < size; y++ {
+		for freq := 0; freq < size; freq++ {
+			var sum float64
+			for x := 0; x < size; x++ {
+				sum += pixels[y*size+x] * cosines[freq*size+x]
+			}
+			rows[y*size+freq] = sum
+		}
+	}
+	res := make([]float64, size*size)
+	for x := 0; x < size; x++ {
+		for freq := 0; freq < size; freq++ {
+			var sum float64
+			for y := 0; y < size; y++ {
+				sum += rows[y*size+x] * cosines[freq*size+y]
+			}
+			res[freq*size+x] = sum
+		}
+	}
+	return res
+}
+
+func medianValue(values []float64) float64 {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
@@ -0,0 +1,267 @@
+package samepic
+
+import (
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+)
+
+const (
+	DefaultTrimBordersTolerance = 0.05
+	DefaultTrimBordersMinKeep   = 0.2
+)
+
+// A Preprocessor normalizes an image before it is
+// compared, so that differences which don't matter for a
+// comparison are removed.
+type Preprocessor interface {
+	Preprocess(img image.Image) image.Image
+}
+
+// PreprocessedSamer wraps a Samer, applying a chain of
+// preprocessors to both images before comparing them.
+type PreprocessedSamer struct {
+	Samer Samer
+
+	// Preprocessors are applied in order.
+	Preprocessors []Preprocessor
+}
+
+// Same preprocesses the images and compares the results.
+func (p *PreprocessedSamer) Same(img1, img2 image.Image) bool {
+	return p.Samer.Same(p.Preprocess(img1), p.Preprocess(img2))
+}
+
+// SameBatch finds pairs of near duplicates.
+//
+// If the wrapped Samer is not a BatchSamer, every pair of
+// images is compared with Same.
+func (p *PreprocessedSamer) SameBatch(images <-chan *IDImage) <-chan *Pair {
+	preprocessed := make(chan *IDImage, 1)
+	go func() {
+		defer close(preprocessed)
+		for image := range images {
+			preprocessed <- &IDImage{Image: p.Preprocess(image.Image), ID: image.ID}
+		}
+	}()
+	if batchSamer, ok := p.Samer.(BatchSamer); ok {
+		return batchSamer.SameBatch(preprocessed)
+	}
+	return pairwiseSameBatch(p.Samer, preprocessed)
+}
+
+// Preprocess applies the preprocessors to an image.
+func (p *PreprocessedSamer) Preprocess(img image.Image) image.Image {
+	for _, preprocessor := range p.Preprocessors {
+		img = preprocessor.Preprocess(img)
+	}
+	return img
+}
+
+// ParsePreprocessors creates a chain of preprocessors
+// from a comma-separated list of names: trim, orient,
+// flatten, equalize, and grayscale.
+//
+// The orient preprocessor is always moved to the front
+// of the chain, since it only works on decoded images.
+//
+// The flatten preprocessor uses a white background by
+// default, but another background may be specified in
+// hex, e.g. "flatten:000000".
+func ParsePreprocessors(spec string) ([]Preprocessor, error) {
+	var res []Preprocessor
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "":
+		case name == "trim":
+			res = append(res, &TrimBorders{})
+		case name == "orient":
+			res = append([]Preprocessor{CorrectOrientation{}}, res...)
+		case name == "flatten":
+			res = append(res, &FlattenAlpha{Background: color.RGBA{0xff, 0xff, 0xff, 0xff}})
+		case strings.HasPrefix(name, "flatten:"):
+			background, err := parseHexColor(strings.TrimPrefix(name, "flatten:"))
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, &FlattenAlpha{Background: background})
+		case name == "equalize":
+			res = append(res, Equalize{})
+		case name == "grayscale":
+			res = append(res, Grayscale{})
+		default:
+			return nil, errors.New("unknown preprocessor: " + name)
+		}
+	}
+	return res, nil
+}
+
+func parseHexColor(s string) (color.RGBA, error) {
+	data, err := hex.DecodeString(strings.TrimPrefix(s, "#"))
+	if err != nil || len(data) != 3 {
+		return color.RGBA{}, errors.New("invalid color: " + s)
+	}
+	return color.RGBA{R: data[0], G: data[1], B: data[2], A: 0xff}, nil
+}
+
+// TrimBorders removes solid borders from the edges of an
+// image, such as letterboxing or padding.
+type TrimBorders struct {
+	// Tolerance is the maximum difference (between 0 and
+	// 1) of any color component from the border's color
+	// for a pixel to be considered part of the border.
+	//
+	// If this is 0, DefaultTrimBordersTolerance is used.
+	Tolerance float64
+
+	// MinKeep is the minimum fraction of each axis which
+	// must remain after trimming.
+	// If more would be trimmed, the image is assumed to
+	// be mostly solid and is left unchanged.
+	//
+	// If this is 0, DefaultTrimBordersMinKeep is used.
+	MinKeep float64
+}
+
+// Preprocess trims the image's borders.
+func (t *TrimBorders) Preprocess(img image.Image) image.Image {
+	tolerance := t.Tolerance
+	if tolerance == 0 {
+		tolerance = DefaultTrimBordersTolerance
+	}
+	minKeep := t.MinKeep
+	if minKeep == 0 {
+		minKeep = DefaultTrimBordersMinKeep
+	}
+
+	b := img.Bounds()
+	if b.Empty() {
+		return img
+	}
+	solid := func(x0, y0, x1, y1 int, border color.Color) bool {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				if colorDistance(img.At(x, y), border) > tolerance {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	trimmed := b
+	topColor := img.At(b.Min.X, b.Min.Y)
+	for trimmed.Dy() > 0 && solid(trimmed.Min.X, trimmed.Min.Y, trimmed.Max.X, trimmed.Min.Y+1, topColor) {
+		trimmed.Min.Y++
+	}
+	bottomColor := img.At(b.Min.X, b.Max.Y-1)
+	for trimmed.Dy() > 0 && solid(trimmed.Min.X, trimmed.Max.Y-1, trimmed.Max.X, trimmed.Max.Y, bottomColor) {
+		trimmed.Max.Y--
+	}
+	leftColor := img.At(b.Min.X, b.Min.Y)
+	for trimmed.Dx() > 0 && solid(trimmed.Min.X, trimmed.Min.Y, trimmed.Min.X+1, trimmed.Max.Y, leftColor) {
+		trimmed.Min.X++
+	}
+	rightColor := img.At(b.Max.X-1, b.Min.Y)
+	for trimmed.Dx() > 0 && solid(trimmed.Max.X-1, trimmed.Min.Y, trimmed.Max.X, trimmed.Max.Y, rightColor) {
+		trimmed.Max.X--
+	}
+
+	if float64(trimmed.Dx()) < minKeep*float64(b.Dx()) ||
+		float64(trimmed.Dy()) < minKeep*float64(b.Dy()) || trimmed == b {
+		return img
+	}
+	return cropImage(img, trimmed.Min.X, trimmed.Min.Y, trimmed.Dx(), trimmed.Dy())
+}
+
+// colorDistance computes the largest difference between
+// the components of two colors, between 0 and 1.
+func colorDistance(c1, c2 color.Color) float64 {
+	r1, g1, b1, a1 := c1.RGBA()
+	r2, g2, b2, a2 := c2.RGBA()
+	var res float64
+	for _, diff := range []float64{
+		float64(r1) - float64(r2),
+		float64(g1) - float64(g2),
+		float64(b1) - float64(b2),
+		float64(a1) - float64(a2),
+	} {
+		res = math.Max(res, math.Abs(diff)/0xffff)
+	}
+	return res
+}
+
+// CorrectOrientation makes images upright according to
+// their EXIF orientation.
+// It only affects images loaded with DecodeImage, since
+// other preprocessors produce images without an EXIF
+// orientation, so it must come before any of them.
+// ParsePreprocessors puts it first automatically.
+type CorrectOrientation struct{}
+
+// Preprocess rotates or flips an *OrientedImage.
+func (c CorrectOrientation) Preprocess(img image.Image) image.Image {
+	if oriented, ok := img.(*OrientedImage); ok {
+		return oriented.Dihedral().Apply(oriented.Image)
+	}
+	return img
+}
+
+// Preprocess flattens the image onto the background.
+func (f *FlattenAlpha) Preprocess(img image.Image) image.Image {
+	return f.Manipulate(img)
+}
+
+// Equalize applies histogram equalization to the
+// brightness of an image, normalizing its contrast and
+// exposure while keeping its colors.
+type Equalize struct{}
+
+// Preprocess equalizes the image.
+func (e Equalize) Preprocess(img image.Image) image.Image {
+	b := img.Bounds()
+	var histogram [256]int
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			brightness, _, _ := color.RGBToYCbCr(c.R, c.G, c.B)
+			histogram[brightness]++
+		}
+	}
+
+	var mapping [256]uint8
+	var cumulative int
+	total := b.Dx() * b.Dy()
+	for i, count := range histogram {
+		cumulative += count
+		mapping[i] = uint8(math.Round(255 * float64(cumulative) / float64(total)))
+	}
+
+	res := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			brightness, cb, cr := color.RGBToYCbCr(c.R, c.G, c.B)
+			r, g, bl := color.YCbCrToRGB(mapping[brightness], cb, cr)
+			res.SetNRGBA(x-b.Min.X, y-b.Min.Y, color.NRGBA{r, g, bl, c.A})
+		}
+	}
+	return res
+}
+
+// Grayscale converts images to grayscale, so that color
+// edits don't affect comparisons.
+type Grayscale struct{}
+
+// Preprocess converts the image to grayscale.
+func (g Grayscale) Preprocess(img image.Image) image.Image {
+	b := img.Bounds()
+	res := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(res, res.Bounds(), img, b.Min, draw.Src)
+	return res
+}
@@ -0,0 +1,102 @@
+package samepic
+
+import (
+	"errors"
+	"image/color"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+const manipulatorPresetPrefix = "preset:"
+
+// FeedUploadManipulator simulates posting an image to a
+// social media feed: the image is shrunk to a fixed long
+// edge, its transparency is removed, and it is
+// re-encoded as a high quality JPEG.
+var FeedUploadManipulator Manipulator = &AggregateManipulator{
+	Manipulators: []Manipulator{
+		&LimitSize{MaxSize: 2048, Interpolation: resize.Bilinear},
+		&FlattenAlpha{Background: color.RGBA{R: 0xff, G: 0xff, B: 0xff}},
+		&CompressJPEG{MinQuality: 80, MaxQuality: 85},
+	},
+	Probabilities: []float64{1, 1, 1},
+}
+
+// ChatUploadManipulator simulates sending an image
+// through a messaging app, which shrinks it further and
+// compresses it more aggressively than a feed upload.
+var ChatUploadManipulator Manipulator = &AggregateManipulator{
+	Manipulators: []Manipulator{
+		&LimitSize{MaxSize: 1600, Interpolation: resize.Bilinear},
+		&FlattenAlpha{Background: color.RGBA{R: 0xff, G: 0xff, B: 0xff}},
+		&CompressJPEG{MinQuality: 65, MaxQuality: 75},
+	},
+	Probabilities: []float64{1, 1, 1},
+}
+
+// StoryManipulator simulates posting an image as a
+// full-screen story, which letterboxes it to a 9:16
+// portrait frame before uploading it.
+var StoryManipulator Manipulator = &AggregateManipulator{
+	Manipulators: []Manipulator{
+		&FlattenAlpha{},
+		&Pad{
+			Fills:     []PadFill{SolidPad, BlurPad},
+			MinAspect: 9.0 / 16,
+			MaxAspect: 9.0 / 16,
+		},
+		&LimitSize{MaxSize: 1920, Interpolation: resize.Bilinear},
+		&CompressJPEG{MinQuality: 75, MaxQuality: 85},
+	},
+	Probabilities: []float64{1, 1, 1, 1},
+}
+
+// ScreenshotManipulator simulates taking a screenshot of
+// an image displayed on a phone and cropping the status
+// bar out of the screenshot.
+var ScreenshotManipulator Manipulator = &AggregateManipulator{
+	Manipulators: []Manipulator{
+		&FlattenAlpha{},
+		&Pad{
+			Fills:     []PadFill{SolidPad},
+			Colors:    []color.RGBA{{A: 0xff}},
+			MinAspect: 9.0 / 19.5,
+			MaxAspect: 9.0 / 19.5,
+		},
+		&CropEdges{Top: 0.05},
+		&LimitSize{MaxSize: 2532, Interpolation: resize.Bilinear},
+	},
+	Probabilities: []float64{1, 1, 1, 1},
+}
+
+// ManipulatorPresets maps preset names to manipulators.
+var ManipulatorPresets = map[string]Manipulator{
+	"default":    DefaultManipulator,
+	"feed":       FeedUploadManipulator,
+	"chat":       ChatUploadManipulator,
+	"story":      StoryManipulator,
+	"screenshot": ScreenshotManipulator,
+}
+
+// ManipulatorFromFlag creates a manipulator from the
+// value of a command-line flag.
+//
+// A value of the form "preset:<name>" selects a preset
+// from ManipulatorPresets.
+// Any other non-empty value is treated as the path to a
+// configuration file for LoadManipulator.
+// An empty value selects DefaultManipulator.
+func ManipulatorFromFlag(value string) (Manipulator, error) {
+	if value == "" {
+		return DefaultManipulator, nil
+	}
+	if strings.HasPrefix(value, manipulatorPresetPrefix) {
+		name := strings.TrimPrefix(value, manipulatorPresetPrefix)
+		if manip, ok := ManipulatorPresets[name]; ok {
+			return manip, nil
+		}
+		return nil, errors.New("unknown manipulator preset: " + name)
+	}
+	return LoadManipulator(value)
+}
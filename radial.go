@@ -0,0 +1,149 @@
+package samepic
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	DefaultRadialVarianceHashScaleSize  = 128
+	DefaultRadialVarianceHashAngleCount = 180
+	DefaultRadialVarianceHashThreshold  = 0.9
+)
+
+// RadialVarianceHash compares two images using the
+// variance of the pixels along lines through the center
+// of each image (i.e. a Radon-style projection).
+//
+// Rotating an image circularly shifts its features, so
+// features are compared with the peak of their circular
+// cross-correlation, making the comparison tolerant to
+// rotation.
+type RadialVarianceHash struct {
+	// ScaleSize is the size to which images are scaled
+	// before computing projections.
+	//
+	// If this is 0, DefaultRadialVarianceHashScaleSize is
+	// used.
+	ScaleSize int
+
+	// AngleCount is the number of evenly-spaced angles
+	// in [0, pi) at which projections are computed.
+	//
+	// If this is 0, DefaultRadialVarianceHashAngleCount
+	// is used.
+	AngleCount int
+
+	// Threshold is the minimum peak correlation between
+	// two images' features for them to be considered the
+	// same.
+	//
+	// If this is 0, DefaultRadialVarianceHashThreshold is
+	// used.
+	Threshold float64
+}
+
+// Same compares the features of two images.
+func (r *RadialVarianceHash) Same(img1, img2 image.Image) bool {
+	return r.match(r.Hash(img1), r.Hash(img2))
+}
+
+// SameBatch finds pairs of near duplicates.
+func (r *RadialVarianceHash) SameBatch(images <-chan *IDImage) <-chan *Pair {
+	res := make(chan *Pair, 1)
+	go func() {
+		defer close(res)
+		ids := []interface{}{}
+		features := [][]float64{}
+		for image := range images {
+			feature := r.Hash(image.Image)
+			for i, feature1 := range features {
+				if r.match(feature, feature1) {
+					res <- &Pair{ids[i], image.ID}
+				}
+			}
+			ids = append(ids, image.ID)
+			features = append(features, feature)
+		}
+	}()
+	return res
+}
+
+// Hash computes the variance of the projection at each
+// angle, normalized to have zero mean and unit norm.
+func (r *RadialVarianceHash) Hash(img image.Image) []float64 {
+	scaleSize := r.ScaleSize
+	if scaleSize == 0 {
+		scaleSize = DefaultRadialVarianceHashScaleSize
+	}
+	angleCount := r.AngleCount
+	if angleCount == 0 {
+		angleCount = DefaultRadialVarianceHashAngleCount
+	}
+	pixels := grayPixels(img, scaleSize, scaleSize)
+
+	center := float64(scaleSize-1) / 2
+	radius := int(math.Ceil(center * math.Sqrt2))
+	res := make([]float64, angleCount)
+	for i := range res {
+		angle := math.Pi * float64(i) / float64(angleCount)
+		cos, sin := math.Cos(angle), math.Sin(angle)
+		var sum, sqSum, count float64
+		for t := -radius; t <= radius; t++ {
+			x := int(math.Floor(center + float64(t)*cos + 0.5))
+			y := int(math.Floor(center + float64(t)*sin + 0.5))
+			if x < 0 || y < 0 || x >= scaleSize || y >= scaleSize {
+				continue
+			}
+			pixel := pixels[y*scaleSize+x]
+			sum += pixel
+			sqSum += pixel * pixel
+			count++
+		}
+		mean := sum / count
+		res[i] = sqSum/count - mean*mean
+	}
+
+	normalizeFeature(res)
+	return res
+}
+
+func (r *RadialVarianceHash) match(f1, f2 []float64) bool {
+	threshold := r.Threshold
+	if threshold == 0 {
+		threshold = DefaultRadialVarianceHashThreshold
+	}
+	for shift := range f1 {
+		var correlation float64
+		for i, x := range f2 {
+			correlation += f1[(i+shift)%len(f1)] * x
+		}
+		if correlation >= threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeFeature scales a vector in place to have zero
+// mean and unit norm, so that dot products between
+// normalized vectors are Pearson correlations.
+func normalizeFeature(v []float64) {
+	var mean float64
+	for _, x := range v {
+		mean += x
+	}
+	mean /= float64(len(v))
+	var norm float64
+	for i, x := range v {
+		v[i] = x - mean
+		norm += v[i] * v[i]
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 {
+		return
+	}
+	for i := range v {
+		v[i] /= norm
+	}
+}
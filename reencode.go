@@ -0,0 +1,180 @@
+package samepic
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"math/rand"
+	"strconv"
+)
+
+const (
+	DefaultReencodeMinGenerations = 1
+	DefaultReencodeMaxGenerations = 4
+)
+
+// A Codec is a lossy encoding which an image may pass
+// through when it is re-uploaded or re-saved.
+type Codec int
+
+const (
+	// JPEGCodec compresses the image with JPEG at a
+	// random quality.
+	JPEGCodec Codec = iota
+
+	// PaletteCodec quantizes the image to a fixed
+	// 216-color palette without dithering and round
+	// trips it through PNG.
+	PaletteCodec
+
+	// GIFCodec quantizes the image to a 256-color
+	// palette with Floyd-Steinberg dithering and round
+	// trips it through GIF.
+	GIFCodec
+
+	// ChromaCodec converts the image to YCbCr with a
+	// random chroma subsampling ratio.
+	ChromaCodec
+)
+
+// A Reencode manipulates images by passing them through
+// a random chain of lossy codecs, simulating an image
+// which has been saved and re-uploaded several times.
+type Reencode struct {
+	// Codecs stores the codecs which may be used at each
+	// generation.
+	// If this is nil, all codecs are allowed.
+	Codecs []Codec
+
+	// These parameters determine the range for the
+	// number of codec round-trips.
+	//
+	// If MinGenerations is 0,
+	// DefaultReencodeMinGenerations is used.
+	// If MaxGenerations is 0,
+	// DefaultReencodeMaxGenerations is used.
+	// The maximum is raised to the minimum if it is
+	// smaller.
+	MinGenerations int
+	MaxGenerations int
+
+	// These parameters control the quality range used by
+	// JPEGCodec, as in CompressJPEG.
+	MinQuality int
+	MaxQuality int
+}
+
+// Manipulate applies a random number of random codec
+// round-trips to the image.
+func (r *Reencode) Manipulate(img image.Image) image.Image {
+	codecs := r.Codecs
+	if codecs == nil {
+		codecs = []Codec{JPEGCodec, PaletteCodec, GIFCodec, ChromaCodec}
+	}
+	min := r.MinGenerations
+	max := r.MaxGenerations
+	if min == 0 {
+		min = DefaultReencodeMinGenerations
+	}
+	if max == 0 {
+		max = DefaultReencodeMaxGenerations
+	}
+	if max < min {
+		max = min
+	}
+	generations := rand.Intn(max-min+1) + min
+	for i := 0; i < generations; i++ {
+		img = r.roundTrip(img, codecs[rand.Intn(len(codecs))])
+	}
+	return img
+}
+
+func (r *Reencode) validate() error {
+	if r.Codecs != nil && len(r.Codecs) == 0 {
+		return errors.New("reencode Codecs is empty")
+	}
+	for _, codec := range r.Codecs {
+		if codec < JPEGCodec || codec > ChromaCodec {
+			return errors.New("unknown codec: " + strconv.Itoa(int(codec)))
+		}
+	}
+	if r.MinGenerations < 0 || r.MaxGenerations < 0 {
+		return errors.New("reencode generations must be positive")
+	}
+	return (&CompressJPEG{MinQuality: r.MinQuality, MaxQuality: r.MaxQuality}).validate()
+}
+
+func (r *Reencode) roundTrip(img image.Image, codec Codec) image.Image {
+	switch codec {
+	case JPEGCodec:
+		jpegManip := &CompressJPEG{MinQuality: r.MinQuality, MaxQuality: r.MaxQuality}
+		return jpegManip.Manipulate(img)
+	case PaletteCodec:
+		paletted := image.NewPaletted(img.Bounds(), palette.WebSafe)
+		draw.Draw(paletted, paletted.Bounds(), img, img.Bounds().Min, draw.Src)
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, paletted); err != nil {
+			panic(err)
+		}
+		res, err := png.Decode(&buf)
+		if err != nil {
+			panic(err)
+		}
+		return res
+	case GIFCodec:
+		var buf bytes.Buffer
+		if err := gif.Encode(&buf, img, &gif.Options{NumColors: 256}); err != nil {
+			panic(err)
+		}
+		res, err := gif.Decode(&buf)
+		if err != nil {
+			panic(err)
+		}
+		return res
+	case ChromaCodec:
+		ratios := []image.YCbCrSubsampleRatio{
+			image.YCbCrSubsampleRatio444,
+			image.YCbCrSubsampleRatio422,
+			image.YCbCrSubsampleRatio420,
+			image.YCbCrSubsampleRatio440,
+			image.YCbCrSubsampleRatio411,
+			image.YCbCrSubsampleRatio410,
+		}
+		return toYCbCr(img, ratios[rand.Intn(len(ratios))])
+	default:
+		panic("unknown codec")
+	}
+}
+
+// toYCbCr converts an image to YCbCr, averaging the
+// chroma components over each subsampled block.
+func toYCbCr(img image.Image, ratio image.YCbCrSubsampleRatio) *image.YCbCr {
+	bounds := img.Bounds()
+	res := image.NewYCbCr(bounds, ratio)
+	cbSums := make([]float64, len(res.Cb))
+	crSums := make([]float64, len(res.Cr))
+	counts := make([]float64, len(res.Cb))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			yy, cb, cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+			res.Y[res.YOffset(x, y)] = yy
+			cOffset := res.COffset(x, y)
+			cbSums[cOffset] += float64(cb)
+			crSums[cOffset] += float64(cr)
+			counts[cOffset]++
+		}
+	}
+	for i, count := range counts {
+		if count > 0 {
+			res.Cb[i] = uint8(cbSums[i]/count + 0.5)
+			res.Cr[i] = uint8(crSums[i]/count + 0.5)
+		}
+	}
+	return res
+}
@@ -1,8 +1,11 @@
 // Command same_gen generates a manipulation of an
-// image to demonstrate samepic.DefaultManipulator.
+// image to demonstrate samepic.DefaultManipulator, a
+// manipulator preset, or a manipulator loaded from a
+// configuration file.
 package main
 
 import (
+	"flag"
 	"fmt"
 	"image"
 	_ "image/jpeg"
@@ -16,11 +19,26 @@ import (
 
 func main() {
 	rand.Seed(time.Now().UnixNano())
-	if len(os.Args) != 3 {
-		fmt.Fprintln(os.Stderr, "Usage", os.Args[0], "original_image manipulated.png")
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	var manipPath string
+	fs.StringVar(&manipPath, "manip", "", "manipulator config file or "+
+		"preset:<name> (defaults to samepic.DefaultManipulator)")
+	fs.Parse(os.Args[1:])
+
+	if len(fs.Args()) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage", os.Args[0], "[-manip config.json|preset:name]",
+			"original_image manipulated.png")
 		os.Exit(1)
 	}
-	inFile, err := os.Open(os.Args[1])
+
+	manipulator, err := samepic.ManipulatorFromFlag(manipPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to load manipulator:", err)
+		os.Exit(1)
+	}
+
+	inFile, err := os.Open(fs.Arg(0))
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Failed to read input:", err)
 		os.Exit(1)
@@ -31,8 +49,8 @@ func main() {
 		fmt.Fprintln(os.Stderr, "Failed to decode input:", err)
 		os.Exit(1)
 	}
-	manip := samepic.DefaultManipulator.Manipulate(img)
-	outFile, err := os.Create(os.Args[2])
+	manip := manipulator.Manipulate(img)
+	outFile, err := os.Create(fs.Arg(1))
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Failed to create output:", err)
 		os.Exit(1)
@@ -33,3 +33,22 @@ type Pair [2]interface{}
 type BatchSamer interface {
 	SameBatch(images <-chan *IDImage) <-chan *Pair
 }
+
+// pairwiseSameBatch implements SameBatch for any Samer
+// by comparing every pair of images.
+func pairwiseSameBatch(samer Samer, images <-chan *IDImage) <-chan *Pair {
+	res := make(chan *Pair, 1)
+	go func() {
+		defer close(res)
+		var seen []*IDImage
+		for image := range images {
+			for _, image1 := range seen {
+				if samer.Same(image1.Image, image.Image) {
+					res <- &Pair{image1.ID, image.ID}
+				}
+			}
+			seen = append(seen, image)
+		}
+	}()
+	return res
+}
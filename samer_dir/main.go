@@ -3,12 +3,14 @@
 //
 // The tool prints pairs of filenames.
 // Every filename is separated by a newline.
+//
+// With the -guard flag, low-information images are
+// left out and reported on standard error.
 package main
 
 import (
 	"flag"
 	"fmt"
-	"image"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -36,6 +38,9 @@ func main() {
 		essentials.Die("Required flag: -dir. See -help.")
 	}
 
+	samerFlags.Rejected = func(id interface{}) {
+		fmt.Fprintln(os.Stderr, "low information:", id)
+	}
 	samer, err := samerFlags.BatchSamer()
 	if err != nil {
 		essentials.Die(err)
@@ -67,7 +72,7 @@ func streamImages(dir string) <-chan *samepic.IDImage {
 			if err != nil {
 				continue
 			}
-			img, _, err := image.Decode(f)
+			img, err := samepic.DecodeImage(f)
 			f.Close()
 			if err != nil {
 				fmt.Fprintln(os.Stderr, "decode "+path+":", err)
@@ -17,8 +17,11 @@ func main() {
 
 	var count int
 	var sampleDir string
+	var manipPath string
 	fs.IntVar(&count, "count", 100, "number of samples to try")
 	fs.StringVar(&sampleDir, "dir", "", "directory of samples")
+	fs.StringVar(&manipPath, "manip", "", "manipulator config file or "+
+		"preset:<name> (defaults to samepic.DefaultManipulator)")
 
 	fs.Parse(os.Args[1:])
 
@@ -36,8 +39,13 @@ func main() {
 		essentials.Die(err)
 	}
 
+	manip, err := samepic.ManipulatorFromFlag(manipPath)
+	if err != nil {
+		essentials.Die(err)
+	}
+
 	fmt.Println("Rating...")
-	pos, neg, err := samepic.Rate(samer, samples, samepic.DefaultManipulator, count)
+	pos, neg, err := samepic.Rate(samer, samples, manip, count)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Failed to rate:", err)
 		os.Exit(1)
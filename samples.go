@@ -25,6 +25,9 @@ type Samples interface {
 
 // DirSamples loads image samples from a directory
 // of image files.
+// Images are made upright according to their EXIF
+// orientation as they are loaded, so that every sample,
+// manipulated or not, has the same orientation.
 type DirSamples struct {
 	imagePaths []string
 }
@@ -55,13 +58,8 @@ func (d *DirSamples) Random() (image.Image, error) {
 	for len(d.imagePaths) > 0 {
 		idx := rand.Intn(len(d.imagePaths))
 		path := d.imagePaths[idx]
-		f, err := os.Open(path)
-		if err == nil {
-			img, _, err := image.Decode(f)
-			f.Close()
-			if err == nil {
-				return img, nil
-			}
+		if img, err := loadSample(path); err == nil {
+			return img, nil
 		}
 		d.imagePaths[idx] = d.imagePaths[len(d.imagePaths)-1]
 		d.imagePaths = d.imagePaths[:len(d.imagePaths)-1]
@@ -81,15 +79,10 @@ func (d *DirSamples) RandomPair() (image.Image, image.Image, error) {
 		if path == lastPath {
 			continue
 		}
-		f, err := os.Open(path)
-		if err == nil {
-			img, _, err := image.Decode(f)
-			f.Close()
-			if err == nil {
-				lastPath = path
-				pair = append(pair, img)
-				continue
-			}
+		if img, err := loadSample(path); err == nil {
+			lastPath = path
+			pair = append(pair, img)
+			continue
 		}
 		d.imagePaths[idx] = d.imagePaths[len(d.imagePaths)-1]
 		d.imagePaths = d.imagePaths[:len(d.imagePaths)-1]
@@ -99,3 +92,20 @@ func (d *DirSamples) RandomPair() (image.Image, image.Image, error) {
 	}
 	return nil, nil, errors.New("no usable pair")
 }
+
+// loadSample decodes an image file and makes it upright.
+func loadSample(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, err := DecodeImage(f)
+	if err != nil {
+		return nil, err
+	}
+	if oriented, ok := img.(*OrientedImage); ok {
+		return oriented.Dihedral().Apply(oriented.Image), nil
+	}
+	return img, nil
+}
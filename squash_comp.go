@@ -1,6 +1,7 @@
 package samepic
 
 import (
+	"errors"
 	"image"
 	"math"
 
@@ -12,6 +13,9 @@ const (
 	DefaultSquashCompMinOverlap = 0.7
 	DefaultSquashCompVectorSize = 150
 	DefaultSquashCompThreshold  = 0.995
+
+	DefaultSquashCompThumbnailSize = 24
+	DefaultSquashComp2DThreshold   = 0.98
 )
 
 type SquashAxis int
@@ -19,8 +23,35 @@ type SquashAxis int
 const (
 	VerticalSquash SquashAxis = iota
 	HorizontalSquash
+
+	// BothSquash compares the lines along both axes and
+	// averages the best correlation for each axis, so
+	// that crops along either axis are accounted for.
+	BothSquash
+
+	// Squash2D doesn't squash the images at all, but
+	// instead scales them to small thumbnails and
+	// searches over the scale and offset along both axes
+	// at once.
+	Squash2D
 )
 
+var squashAxisNames = map[string]SquashAxis{
+	"vertical":   VerticalSquash,
+	"horizontal": HorizontalSquash,
+	"both":       BothSquash,
+	"2d":         Squash2D,
+}
+
+// ParseSquashAxis finds the axis with the given name
+// (vertical, horizontal, both, or 2d).
+func ParseSquashAxis(name string) (SquashAxis, error) {
+	if axis, ok := squashAxisNames[name]; ok {
+		return axis, nil
+	}
+	return 0, errors.New("unknown squash axis: " + name)
+}
+
 // SquashComp compares images by "squashing" them into
 // one-dimensional lines (by scaling the image) and
 // measuring the correlation between the lines.
@@ -55,26 +86,80 @@ type SquashComp struct {
 	// is used.
 	VectorSize int
 
+	// ThumbnailSize is the width and height to which
+	// images are scaled for Squash2D.
+	//
+	// If this is 0, DefaultSquashCompThumbnailSize is
+	// used.
+	ThumbnailSize int
+
 	// Threshold is the minimum correlation to trigger
 	// a positive match.
 	//
-	// If this is 0, DefaultSquashCompThreshold is used.
+	// If this is 0, DefaultSquashCompThreshold is used,
+	// or DefaultSquashComp2DThreshold for Squash2D.
 	Threshold float64
 }
 
 // Same uses squashed correlations to determine if two
 // images are the same.
 func (s *SquashComp) Same(img1, img2 image.Image) bool {
-	return s.asymmetricalSame(img1, img2) || s.asymmetricalSame(img2, img1)
+	switch s.Axis {
+	case BothSquash:
+		return s.bothCorrelation(img1, img2) >= s.threshold()
+	case Squash2D:
+		return s.alignment2D(img1, img2).Correlation >= s.threshold() ||
+			s.alignment2D(img2, img1).Correlation >= s.threshold()
+	default:
+		return s.asymmetricalSame(img1, img2) || s.asymmetricalSame(img2, img1)
+	}
+}
+
+// bothCorrelation averages the best correlations of the
+// lines along each axis.
+func (s *SquashComp) bothCorrelation(img1, img2 image.Image) float64 {
+	var sum float64
+	for _, axis := range []SquashAxis{VerticalSquash, HorizontalSquash} {
+		axisComp := *s
+		axisComp.Axis = axis
+		sum += math.Max(axisComp.alignment(img1, img2, 3).Correlation,
+			axisComp.alignment(img2, img1, 3).Correlation)
+	}
+	return sum / 2
 }
 
 // asymmetricalSame keeps the first image at exactly
 // s.VectorSize and scales+translates the other one.
 func (s *SquashComp) asymmetricalSame(img1, img2 image.Image) bool {
-	vectorSize := s.VectorSize
-	if vectorSize == 0 {
-		vectorSize = DefaultSquashCompVectorSize
-	}
+	return s.alignment(img1, img2, 1).Correlation >= s.threshold()
+}
+
+// A squashAlignment describes the best placement of one
+// squashed line along another.
+type squashAlignment struct {
+	// Size is the size to which the second image was
+	// squashed, while the first image was squashed to
+	// the vector size.
+	Size int
+
+	// Offset is the index of the first vector component
+	// which lines up with the start of the second vector.
+	// It may be negative if the second vector starts
+	// before the first one.
+	Offset int
+
+	Correlation float64
+}
+
+// alignment searches the sizes and offsets of the second
+// image's line for the best correlation with the first
+// image's line.
+//
+// Offsets are searched in steps of stride components,
+// over a range of stride components per pixel of slack.
+// A stride of 3 searches every whole-pixel offset.
+func (s *SquashComp) alignment(img1, img2 image.Image, stride int) squashAlignment {
+	vectorSize := s.vectorSize()
 	minOverlap := s.MinOverlap
 	if minOverlap == 0 {
 		minOverlap = DefaultSquashCompMinOverlap
@@ -83,17 +168,37 @@ func (s *SquashComp) asymmetricalSame(img1, img2 image.Image) bool {
 	mainVec := s.squash(img1, vectorSize)
 	minSize := int(math.Ceil(float64(vectorSize) * minOverlap))
 
+	// The dot products at every offset are computed at
+	// once with an FFT, and the magnitudes of the
+	// overlapping parts come from prefix sums.
+	correlator := newCrossCorrelator(mainVec, len(mainVec))
+	mainSquares := squarePrefixSums(mainVec)
+
+	best := squashAlignment{Correlation: math.Inf(-1)}
 	for size := minSize; size <= vectorSize; size++ {
 		secondaryVec := s.squash(img2, size)
+		secondarySquares := squarePrefixSums(secondaryVec)
+		dots := correlator.Correlate(secondaryVec)
 		allowedMiss := size - minSize
-		for x := -allowedMiss; x <= vectorSize-size+allowedMiss; x++ {
-			if s.vectorMatch(mainVec, secondaryVec, x) {
-				return true
+		for x := -allowedMiss * stride; x <= (vectorSize-size+allowedMiss)*stride; x += stride {
+			start1, start2 := x, 0
+			if x < 0 {
+				start1, start2 = 0, -x
+			}
+			length := minInt(len(mainVec)-start1, len(secondaryVec)-start2)
+			mag1 := mainSquares[start1+length] - mainSquares[start1]
+			mag2 := secondarySquares[start2+length] - secondarySquares[start2]
+			if mag1 <= 0 || mag2 <= 0 {
+				continue
+			}
+			cor := dots[(x+len(dots))%len(dots)] / math.Sqrt(mag1*mag2)
+			if cor > best.Correlation {
+				best = squashAlignment{Size: size, Offset: x, Correlation: cor}
 			}
 		}
 	}
 
-	return false
+	return best
 }
 
 // squash generates a squashed image vector of size n*3,
@@ -122,24 +227,109 @@ func (s *SquashComp) squash(img image.Image, n int) linalg.Vector {
 	return res
 }
 
-// vectorMatch calculates the correlation of two vectors
-// (one with an offset) and determines if the correlation
-// exceeds the match threshold.
-func (s *SquashComp) vectorMatch(v1, v2 linalg.Vector, v2Offset int) bool {
-	if v2Offset < 0 {
-		v2 = v2[-v2Offset:]
-	} else {
-		v1 = v1[v2Offset:]
-		if len(v1) < len(v2) {
-			v2 = v2[:len(v1)]
+// A squashAlignment2D describes the best placement of
+// one thumbnail on another.
+type squashAlignment2D struct {
+	// Width and Height are the size to which the second
+	// image was scaled, while the first image was scaled
+	// to the thumbnail size.
+	Width  int
+	Height int
+
+	// X and Y are the pixel in the first thumbnail which
+	// lines up with the top-left corner of the second
+	// thumbnail.
+	X int
+	Y int
+
+	Correlation float64
+}
+
+// alignment2D searches the sizes and offsets of the
+// second image's thumbnail for the best correlation with
+// the first image's thumbnail.
+func (s *SquashComp) alignment2D(img1, img2 image.Image) squashAlignment2D {
+	size := s.ThumbnailSize
+	if size == 0 {
+		size = DefaultSquashCompThumbnailSize
+	}
+	minOverlap := s.MinOverlap
+	if minOverlap == 0 {
+		minOverlap = DefaultSquashCompMinOverlap
+	}
+
+	mainThumb := squashThumbnail(img1, size, size)
+	minSize := int(math.Ceil(float64(size) * minOverlap))
+
+	best := squashAlignment2D{Correlation: math.Inf(-1)}
+	for height := minSize; height <= size; height++ {
+		for width := minSize; width <= size; width++ {
+			thumb := squashThumbnail(img2, width, height)
+			missX, missY := width-minSize, height-minSize
+			for y := -missY; y <= size-height+missY; y++ {
+				for x := -missX; x <= size-width+missX; x++ {
+					cor := thumbnailCorrelation(mainThumb, size, thumb, width, x, y)
+					if cor > best.Correlation {
+						best = squashAlignment2D{width, height, x, y, cor}
+					}
+				}
+			}
+		}
+	}
+	return best
+}
+
+// squashThumbnail scales an image and packs the R, G,
+// and B components of its pixels in row-major order.
+func squashThumbnail(img image.Image, width, height int) linalg.Vector {
+	scaledImg := resize.Resize(uint(width), uint(height), img, resize.Bilinear)
+	res := make(linalg.Vector, 0, width*height*3)
+	b := scaledImg.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, b, _ := scaledImg.At(x, y).RGBA()
+			res = append(res, float64(r)/0xffff, float64(g)/0xffff, float64(b)/0xffff)
 		}
 	}
-	v1 = v1[:len(v2)]
-	cor := v1.Dot(v2) / (v1.Mag() * v2.Mag())
+	return res
+}
 
+// thumbnailCorrelation computes the correlation between
+// the overlapping parts of two thumbnails, where the
+// second thumbnail's top-left corner is at (x, y) in the
+// first thumbnail.
+func thumbnailCorrelation(t1 linalg.Vector, width1 int, t2 linalg.Vector, width2, x, y int) float64 {
+	height1 := len(t1) / (width1 * 3)
+	height2 := len(t2) / (width2 * 3)
+	minX, maxX := maxInt(0, x), minInt(width1, x+width2)
+	minY, maxY := maxInt(0, y), minInt(height1, y+height2)
+
+	var dot, mag1, mag2 float64
+	for row := minY; row < maxY; row++ {
+		row1 := t1[(row*width1+minX)*3 : (row*width1+maxX)*3]
+		row2 := t2[((row-y)*width2+minX-x)*3:]
+		for i, a := range row1 {
+			b := row2[i]
+			dot += a * b
+			mag1 += a * a
+			mag2 += b * b
+		}
+	}
+	return dot / math.Sqrt(mag1*mag2)
+}
+
+func (s *SquashComp) vectorSize() int {
+	if s.VectorSize == 0 {
+		return DefaultSquashCompVectorSize
+	}
+	return s.VectorSize
+}
+
+func (s *SquashComp) threshold() float64 {
 	if s.Threshold != 0 {
-		return cor >= s.Threshold
-	} else {
-		return cor >= DefaultSquashCompThreshold
+		return s.Threshold
+	} else if s.Axis == Squash2D {
+		return DefaultSquashComp2DThreshold
 	}
+	return DefaultSquashCompThreshold
 }
@@ -0,0 +1,116 @@
+package samepic
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+func TestCrossCorrelator(t *testing.T) {
+	for _, lengths := range [][2]int{{1, 1}, {5, 3}, {16, 16}, {30, 7}, {72, 72}, {100, 60}} {
+		signal := randomVector(lengths[0])
+		other := randomVector(lengths[1])
+		dots := newCrossCorrelator(signal, len(other)).Correlate(other)
+		for x := -(len(other) - 1); x < len(signal); x++ {
+			var expected float64
+			for i, y := range other {
+				if i+x >= 0 && i+x < len(signal) {
+					expected += signal[i+x] * y
+				}
+			}
+			actual := dots[(x+len(dots))%len(dots)]
+			if math.Abs(actual-expected) > 1e-8 {
+				t.Errorf("lengths %v offset %d: expected %f but got %f",
+					lengths, x, expected, actual)
+			}
+		}
+	}
+}
+
+func TestSquashCompAlignment(t *testing.T) {
+	rand.Seed(1337)
+	for i := 0; i < 5; i++ {
+		img1 := randomRectImage(120, 80)
+		img2 := cropImage(img1, 10, 0, 100, 80)
+		img3 := randomRectImage(120, 80)
+		for _, axis := range []SquashAxis{VerticalSquash, HorizontalSquash} {
+			s := &SquashComp{Axis: axis}
+			for _, pair := range [][2]image.Image{{img1, img2}, {img2, img1}, {img1, img3}} {
+				for _, stride := range []int{1, 3} {
+					actual := s.alignment(pair[0], pair[1], stride)
+					expected := bruteForceAlignment(s, pair[0], pair[1], stride)
+					if math.Abs(actual.Correlation-expected.Correlation) > 1e-8 {
+						t.Errorf("expected correlation %f but got %f",
+							expected.Correlation, actual.Correlation)
+					}
+					threshold := s.threshold()
+					if (actual.Correlation >= threshold) != (expected.Correlation >= threshold) {
+						t.Errorf("decision differs for correlation %f", expected.Correlation)
+					}
+				}
+			}
+		}
+	}
+}
+
+// bruteForceAlignment is the direct implementation of
+// SquashComp.alignment, computing every correlation
+// separately.
+func bruteForceAlignment(s *SquashComp, img1, img2 image.Image, stride int) squashAlignment {
+	vectorSize := s.vectorSize()
+	mainVec := s.squash(img1, vectorSize)
+	minSize := int(math.Ceil(float64(vectorSize) * DefaultSquashCompMinOverlap))
+	best := squashAlignment{Correlation: math.Inf(-1)}
+	for size := minSize; size <= vectorSize; size++ {
+		secondaryVec := s.squash(img2, size)
+		allowedMiss := size - minSize
+		for x := -allowedMiss * stride; x <= (vectorSize-size+allowedMiss)*stride; x += stride {
+			v1, v2 := mainVec, secondaryVec
+			if x < 0 {
+				v2 = v2[-x:]
+			} else {
+				v1 = v1[x:]
+			}
+			if len(v1) < len(v2) {
+				v2 = v2[:len(v1)]
+			}
+			v1 = v1[:len(v2)]
+			if cor := v1.Dot(v2) / (v1.Mag() * v2.Mag()); cor > best.Correlation {
+				best = squashAlignment{Size: size, Offset: x, Correlation: cor}
+			}
+		}
+	}
+	return best
+}
+
+func randomVector(n int) linalg.Vector {
+	res := make(linalg.Vector, n)
+	for i := range res {
+		res[i] = rand.NormFloat64()
+	}
+	return res
+}
+
+func randomRectImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < 10; i++ {
+		c := color.RGBA{
+			R: uint8(rand.Intn(256)),
+			G: uint8(rand.Intn(256)),
+			B: uint8(rand.Intn(256)),
+			A: 0xff,
+		}
+		x, y := rand.Intn(width), rand.Intn(height)
+		w, h := rand.Intn(width-x)+1, rand.Intn(height-y)+1
+		for row := y; row < y+h; row++ {
+			for col := x; col < x+w; col++ {
+				img.SetRGBA(col, row, c)
+			}
+		}
+	}
+	return img
+}
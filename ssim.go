@@ -0,0 +1,269 @@
+package samepic
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	DefaultSSIMSize       = 64
+	DefaultSSIMScales     = 1
+	DefaultSSIMWindowSize = 8
+	DefaultSSIMMinOverlap = 0.7
+	DefaultSSIMThreshold  = 0.6
+)
+
+// These constants stabilize the SSIM ratios for
+// brightness values between 0 and 1.
+const (
+	ssimC1 = 0.01 * 0.01
+	ssimC2 = 0.03 * 0.03
+)
+
+// msssimWeights are the per-scale exponents from the
+// original MS-SSIM paper, from finest to coarsest.
+var msssimWeights = []float64{0.0448, 0.2856, 0.3001, 0.2363, 0.1333}
+
+// SSIM compares images by their mean structural
+// similarity, which measures how well local luminance,
+// contrast, and structure agree between the images.
+// It is intended for high-precision verification of
+// candidate pairs found by a cheaper samer.
+//
+// With more than one scale, SSIM computes multi-scale
+// SSIM (MS-SSIM) instead.
+type SSIM struct {
+	// Size is the size to which both images are scaled
+	// before they are compared.
+	//
+	// If this is 0, DefaultSSIMSize is used.
+	Size int
+
+	// Scales is the number of scales for MS-SSIM, where
+	// each scale is half the size of the previous one.
+	// At most len(msssimWeights) scales are used.
+	//
+	// If this is 0, DefaultSSIMScales is used.
+	Scales int
+
+	// WindowSize is the size of the square windows over
+	// which local statistics are computed.
+	// Neighboring windows overlap by half a window.
+	//
+	// If this is 0, DefaultSSIMWindowSize is used.
+	WindowSize int
+
+	// Align, if true, searches for the crop and scale
+	// that best align the images before computing SSIM,
+	// much like SquashComp searches for the best scale
+	// and offset of its squashed lines.
+	Align bool
+
+	// MinOverlap is the smallest fraction of each axis of
+	// one image which may be covered by the other image
+	// during alignment.
+	//
+	// If this is 0, DefaultSSIMMinOverlap is used.
+	MinOverlap float64
+
+	// Threshold is the minimum similarity for two images
+	// to be considered the same.
+	//
+	// If this is 0, DefaultSSIMThreshold is used.
+	Threshold float64
+}
+
+// Same computes the (possibly aligned) SSIM between two
+// images and compares it to the threshold.
+func (s *SSIM) Same(img1, img2 image.Image) bool {
+	return s.matrixSimilarity(s.matrix(img1), s.matrix(img2)) >= s.threshold()
+}
+
+// SameBatch finds pairs of near duplicates.
+func (s *SSIM) SameBatch(images <-chan *IDImage) <-chan *Pair {
+	res := make(chan *Pair, 1)
+	go func() {
+		defer close(res)
+		ids := []interface{}{}
+		matrices := []*grayMatrix{}
+		for image := range images {
+			matrix := s.matrix(image.Image)
+			for i, matrix1 := range matrices {
+				if s.matrixSimilarity(matrix1, matrix) >= s.threshold() {
+					res <- &Pair{ids[i], image.ID}
+				}
+			}
+			ids = append(ids, image.ID)
+			matrices = append(matrices, matrix)
+		}
+	}()
+	return res
+}
+
+// Similarity computes the (possibly aligned) SSIM or
+// MS-SSIM between two images.
+// The result is at most 1, with 1 indicating identical
+// images.
+func (s *SSIM) Similarity(img1, img2 image.Image) float64 {
+	return s.matrixSimilarity(s.matrix(img1), s.matrix(img2))
+}
+
+func (s *SSIM) matrix(img image.Image) *grayMatrix {
+	size := s.Size
+	if size == 0 {
+		size = DefaultSSIMSize
+	}
+	return newGrayMatrix(img, size, size)
+}
+
+func (s *SSIM) matrixSimilarity(m1, m2 *grayMatrix) float64 {
+	if !s.Align {
+		return s.similarity(m1, m2)
+	}
+	return math.Max(s.alignedSimilarity(m1, m2), s.alignedSimilarity(m2, m1))
+}
+
+// alignedSimilarity keeps m1 fixed and searches over
+// regions of m1 to which m2 may be scaled.
+func (s *SSIM) alignedSimilarity(m1, m2 *grayMatrix) float64 {
+	minOverlap := s.MinOverlap
+	if minOverlap == 0 {
+		minOverlap = DefaultSSIMMinOverlap
+	}
+
+	// Searching every pixel offset would be too slow,
+	// so we search a coarse grid of sizes and offsets.
+	step := m1.Width / 16
+	if step < 1 {
+		step = 1
+	}
+	minWidth := int(math.Ceil(float64(m1.Width) * minOverlap))
+	minHeight := int(math.Ceil(float64(m1.Height) * minOverlap))
+
+	best := math.Inf(-1)
+	for width := m1.Width; width >= minWidth; width -= step {
+		for height := m1.Height; height >= minHeight; height -= step {
+			scaled := m2.Resize(width, height)
+			for y := 0; y <= m1.Height-height; y += step {
+				for x := 0; x <= m1.Width-width; x += step {
+					region := m1.Crop(x, y, width, height)
+					best = math.Max(best, s.similarity(region, scaled))
+				}
+			}
+		}
+	}
+	return best
+}
+
+// similarity computes SSIM or MS-SSIM between two
+// matrices of the same size.
+func (s *SSIM) similarity(m1, m2 *grayMatrix) float64 {
+	scales := s.Scales
+	if scales == 0 {
+		scales = DefaultSSIMScales
+	}
+	if scales > len(msssimWeights) {
+		scales = len(msssimWeights)
+	}
+	windowSize := s.WindowSize
+	if windowSize == 0 {
+		windowSize = DefaultSSIMWindowSize
+	}
+
+	if scales == 1 {
+		_, ssim := ssimStatistics(m1, m2, windowSize)
+		return ssim
+	}
+
+	// Stop early if the images get too small, using the
+	// SSIM at the coarsest scale we reach.
+	var usedWeights []float64
+	var contrasts []float64
+	var ssim float64
+	for scale := 0; scale < scales; scale++ {
+		var contrast float64
+		contrast, ssim = ssimStatistics(m1, m2, windowSize)
+		usedWeights = append(usedWeights, msssimWeights[scale])
+		contrasts = append(contrasts, contrast)
+		if m1.Width/2 < windowSize || m1.Height/2 < windowSize {
+			break
+		}
+		m1 = m1.Downsample()
+		m2 = m2.Downsample()
+	}
+
+	var weightSum float64
+	for _, w := range usedWeights {
+		weightSum += w
+	}
+	res := 1.0
+	for i, w := range usedWeights {
+		value := contrasts[i]
+		if i == len(usedWeights)-1 {
+			value = ssim
+		}
+		res *= math.Pow(math.Max(0, value), w/weightSum)
+	}
+	return res
+}
+
+// ssimStatistics computes the mean contrast-structure
+// term and the mean SSIM over all windows.
+func ssimStatistics(m1, m2 *grayMatrix, windowSize int) (contrast, ssim float64) {
+	windowWidth := windowSize
+	if windowWidth > m1.Width {
+		windowWidth = m1.Width
+	}
+	windowHeight := windowSize
+	if windowHeight > m1.Height {
+		windowHeight = m1.Height
+	}
+	stepX := windowStep(windowWidth)
+	stepY := windowStep(windowHeight)
+
+	var count float64
+	for y := 0; y+windowHeight <= m1.Height; y += stepY {
+		for x := 0; x+windowWidth <= m1.Width; x += stepX {
+			var sum1, sum2, sqSum1, sqSum2, prodSum float64
+			for wy := y; wy < y+windowHeight; wy++ {
+				for wx := x; wx < x+windowWidth; wx++ {
+					p1 := m1.At(wx, wy)
+					p2 := m2.At(wx, wy)
+					sum1 += p1
+					sum2 += p2
+					sqSum1 += p1 * p1
+					sqSum2 += p2 * p2
+					prodSum += p1 * p2
+				}
+			}
+			n := float64(windowWidth * windowHeight)
+			mean1, mean2 := sum1/n, sum2/n
+			var1 := sqSum1/n - mean1*mean1
+			var2 := sqSum2/n - mean2*mean2
+			covar := prodSum/n - mean1*mean2
+
+			luminance := (2*mean1*mean2 + ssimC1) / (mean1*mean1 + mean2*mean2 + ssimC1)
+			cs := (2*covar + ssimC2) / (var1 + var2 + ssimC2)
+			contrast += cs
+			ssim += luminance * cs
+			count++
+		}
+	}
+	return contrast / count, ssim / count
+}
+
+// windowStep gets the stride between windows of a
+// given size, which is half the window size.
+func windowStep(windowSize int) int {
+	if windowSize < 2 {
+		return 1
+	}
+	return windowSize / 2
+}
+
+func (s *SSIM) threshold() float64 {
+	if s.Threshold == 0 {
+		return DefaultSSIMThreshold
+	}
+	return s.Threshold
+}
@@ -0,0 +1,204 @@
+package samepic
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+// A Transform describes how a near duplicate image was
+// derived from another image.
+type Transform struct {
+	// Dihedral is the rotation or flip which was applied
+	// to the first image.
+	Dihedral Dihedral
+
+	// Bounds1 and Bounds2 are the bounds of the two
+	// images.
+	Bounds1 image.Rectangle
+	Bounds2 image.Rectangle
+
+	// Region1 is the region of the first image which
+	// appears in the second image, in the first image's
+	// coordinates.
+	Region1 image.Rectangle
+
+	// Region2 is the region of the second image which
+	// shows Region1, in the second image's coordinates.
+	Region2 image.Rectangle
+
+	// ScaleX and ScaleY are the factors by which Region1
+	// was scaled (after applying Dihedral) along each
+	// axis of the second image.
+	ScaleX float64
+	ScaleY float64
+
+	// Quality1 and Quality2 are the estimated JPEG
+	// qualities of the two images, or 0 if an image does
+	// not appear to have been compressed with JPEG.
+	Quality1 int
+	Quality2 int
+
+	// Correlation is the mean correlation between the
+	// aligned images' squashed lines, indicating how well
+	// the transform explains the second image.
+	Correlation float64
+}
+
+// String describes the transform in plain language,
+// e.g. "image 2 shows 70% of image 1, scaled by 0.50".
+func (t *Transform) String() string {
+	parts := []string{
+		fmt.Sprintf("image 2 shows %.0f%% of image 1", 100*regionFraction(t.Region1, t.Bounds1)),
+	}
+	if fraction := regionFraction(t.Region2, t.Bounds2); fraction < 0.99 {
+		parts = append(parts, fmt.Sprintf("image 1 shows %.0f%% of image 2", 100*fraction))
+	}
+	if t.Dihedral != Identity {
+		parts = append(parts, t.Dihedral.String())
+	}
+	if math.Abs(t.ScaleX-t.ScaleY) <= 0.05*math.Max(t.ScaleX, t.ScaleY) {
+		parts = append(parts, fmt.Sprintf("scaled by %.2f", (t.ScaleX+t.ScaleY)/2))
+	} else {
+		parts = append(parts, fmt.Sprintf("scaled by %.2f horizontally and %.2f vertically",
+			t.ScaleX, t.ScaleY))
+	}
+	if t.Quality1 != 0 || t.Quality2 != 0 {
+		parts = append(parts, fmt.Sprintf("JPEG quality %s vs. %s",
+			qualityString(t.Quality1), qualityString(t.Quality2)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// EstimateTransform finds the crop, scale, rotation, and
+// flip which best map img1 onto img2, and estimates the
+// JPEG quality of each image.
+//
+// Like Same, this searches the scales and offsets of
+// squashed lines, but it does so along both axes and
+// for every Dihedral.
+// The Axis and Threshold fields are ignored.
+func (s *SquashComp) EstimateTransform(img1, img2 image.Image) *Transform {
+	// Squashing scales the images many times, so it is
+	// much faster to work with thumbnails.
+	thumbSize := s.vectorSize() * 2
+	thumb1 := transformThumbnail(img1, thumbSize)
+	thumb2 := transformThumbnail(img2, thumbSize)
+
+	xComp := &SquashComp{Axis: VerticalSquash, MinOverlap: s.MinOverlap, VectorSize: s.VectorSize}
+	yComp := &SquashComp{Axis: HorizontalSquash, MinOverlap: s.MinOverlap, VectorSize: s.VectorSize}
+
+	var res *Transform
+	for _, d := range Dihedrals {
+		transformed := d.Apply(thumb1)
+		xLo1, xHi1, xLo2, xHi2, xCor := xComp.axisCorrespondence(transformed, thumb2)
+		yLo1, yHi1, yLo2, yHi2, yCor := yComp.axisCorrespondence(transformed, thumb2)
+		correlation := (xCor + yCor) / 2
+		if res != nil && !(correlation > res.Correlation) {
+			continue
+		}
+
+		b1, b2 := img1.Bounds(), img2.Bounds()
+		width1, height1 := float64(b1.Dx()), float64(b1.Dy())
+		if d.SwapsAxes() {
+			width1, height1 = height1, width1
+		}
+		width2, height2 := float64(b2.Dx()), float64(b2.Dy())
+
+		// Map the corners of the region in the transformed
+		// image back to the original image.
+		inverse := d.Inverse()
+		cornerX1, cornerY1 := inverse.Point(xLo1*width1, yLo1*height1, width1, height1)
+		cornerX2, cornerY2 := inverse.Point(xHi1*width1, yHi1*height1, width1, height1)
+		region1 := image.Rect(
+			int(math.Min(cornerX1, cornerX2)+0.5), int(math.Min(cornerY1, cornerY2)+0.5),
+			int(math.Max(cornerX1, cornerX2)+0.5), int(math.Max(cornerY1, cornerY2)+0.5),
+		).Add(b1.Min)
+		region2 := image.Rect(
+			int(xLo2*width2+0.5), int(yLo2*height2+0.5),
+			int(xHi2*width2+0.5), int(yHi2*height2+0.5),
+		).Add(b2.Min)
+
+		res = &Transform{
+			Dihedral:    d,
+			Bounds1:     b1,
+			Bounds2:     b2,
+			Region1:     region1,
+			Region2:     region2,
+			ScaleX:      (xHi2 - xLo2) * width2 / ((xHi1 - xLo1) * width1),
+			ScaleY:      (yHi2 - yLo2) * height2 / ((yHi1 - yLo1) * height1),
+			Correlation: correlation,
+		}
+	}
+
+	res.Quality1 = EstimateJPEGQuality(img1)
+	res.Quality2 = EstimateJPEGQuality(img2)
+	return res
+}
+
+// axisCorrespondence finds the intervals along the
+// squashing axis (as fractions of each image's extent)
+// which line up in the two images, trying both images
+// as the fixed line.
+func (s *SquashComp) axisCorrespondence(img1, img2 image.Image) (lo1, hi1, lo2, hi2,
+	correlation float64) {
+	forward := s.alignment(img1, img2, 3)
+	backward := s.alignment(img2, img1, 3)
+	if backward.Correlation > forward.Correlation {
+		lo2, hi2, lo1, hi1 = s.alignmentIntervals(backward)
+		return lo1, hi1, lo2, hi2, backward.Correlation
+	}
+	lo1, hi1, lo2, hi2 = s.alignmentIntervals(forward)
+	return lo1, hi1, lo2, hi2, forward.Correlation
+}
+
+// alignmentIntervals converts an alignment into the
+// overlapping intervals of the fixed and moving lines,
+// as fractions of each line's length.
+func (s *SquashComp) alignmentIntervals(a squashAlignment) (fixedLo, fixedHi,
+	movingLo, movingHi float64) {
+	vectorSize := float64(s.vectorSize())
+	size := float64(a.Size)
+
+	// Offsets count vector components, of which there
+	// are three per pixel.
+	offset := float64(a.Offset) / 3
+
+	lo := math.Max(0, offset)
+	hi := math.Min(vectorSize, offset+size)
+	return lo / vectorSize, hi / vectorSize, (lo - offset) / size, (hi - offset) / size
+}
+
+// transformThumbnail scales an image down so that its
+// long edge is at most maxSize.
+func transformThumbnail(img image.Image, maxSize int) image.Image {
+	b := img.Bounds()
+	scale := float64(maxSize) / float64(maxInt(b.Dx(), b.Dy()))
+	if scale >= 1 {
+		return img
+	}
+	width := maxInt(1, int(float64(b.Dx())*scale+0.5))
+	height := maxInt(1, int(float64(b.Dy())*scale+0.5))
+	return resize.Resize(uint(width), uint(height), img, resize.Bilinear)
+}
+
+// regionFraction computes the fraction of the bounds'
+// area which is covered by a region.
+func regionFraction(region, bounds image.Rectangle) float64 {
+	area := bounds.Dx() * bounds.Dy()
+	if area == 0 {
+		return 0
+	}
+	region = region.Intersect(bounds)
+	return float64(region.Dx()*region.Dy()) / float64(area)
+}
+
+func qualityString(quality int) string {
+	if quality == 0 {
+		return "unknown"
+	}
+	return fmt.Sprint(quality)
+}
@@ -0,0 +1,257 @@
+package samepic
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"strconv"
+)
+
+const (
+	DefaultAffineMinStretch = 1.0
+	DefaultAffineMaxStretch = 1.0
+)
+
+// WarpInterpolation is a technique for sampling source
+// pixels when warping an image.
+type WarpInterpolation int
+
+const (
+	BilinearWarp WarpInterpolation = iota
+	NearestWarp
+)
+
+// An Affine manipulates images by applying a random
+// shear and non-uniform stretch to them, as happens when
+// a print is photographed at a slight angle.
+type Affine struct {
+	// Interpolations stores the allowed interpolation
+	// techniques for sampling the source image.
+	// If this is nil, all techniques are allowed.
+	Interpolations []WarpInterpolation
+
+	// MaxShear is the largest absolute shear factor that
+	// may be applied along each axis.
+	// For example, a shear factor of 0.1 moves the
+	// bottom of the image horizontally by 10% of the
+	// image's height.
+	MaxShear float64
+
+	// These parameters determine the range of stretch
+	// factors, which are chosen independently for the
+	// x and y axes.
+	//
+	// If MinStretch is 0, DefaultAffineMinStretch is used.
+	// If MaxStretch is 0, DefaultAffineMaxStretch is used.
+	// Manipulate panics if the range is negative or
+	// inverted.
+	MinStretch float64
+	MaxStretch float64
+
+	// Background is the color used for regions of the
+	// output which do not map back into the image.
+	// The zero value is transparent black.
+	Background color.RGBA
+}
+
+// Manipulate randomly shears and stretches the image.
+// The resulting image is large enough to contain the
+// entire transformed image.
+func (a *Affine) Manipulate(img image.Image) image.Image {
+	minStretch, maxStretch, err := a.stretchRange()
+	if err != nil {
+		panic(err)
+	}
+	stretchX := rand.Float64()*(maxStretch-minStretch) + minStretch
+	stretchY := rand.Float64()*(maxStretch-minStretch) + minStretch
+	shearX := (rand.Float64()*2 - 1) * a.MaxShear
+	shearY := (rand.Float64()*2 - 1) * a.MaxShear
+
+	forward := homography{
+		stretchX, shearX * stretchX, 0,
+		shearY * stretchY, stretchY, 0,
+		0, 0, 1,
+	}
+
+	width := float64(img.Bounds().Dx())
+	height := float64(img.Bounds().Dy())
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, corner := range [][2]float64{{0, 0}, {width, 0}, {0, height}, {width, height}} {
+		x, y := forward.Apply(corner[0], corner[1])
+		minX, minY = math.Min(minX, x), math.Min(minY, y)
+		maxX, maxY = math.Max(maxX, x), math.Max(maxY, y)
+	}
+	forward[2] = -minX
+	forward[5] = -minY
+
+	inverse, ok := forward.Inverse()
+	if !ok {
+		return img
+	}
+	newWidth := int(math.Max(1, math.Ceil(maxX-minX)))
+	newHeight := int(math.Max(1, math.Ceil(maxY-minY)))
+	return warpImage(img, inverse, newWidth, newHeight,
+		randomWarpInterpolation(a.Interpolations), a.Background)
+}
+
+func (a *Affine) stretchRange() (min, max float64, err error) {
+	min, max = a.MinStretch, a.MaxStretch
+	if min == 0 {
+		min = DefaultAffineMinStretch
+	}
+	if max == 0 {
+		max = DefaultAffineMaxStretch
+	}
+	if min < 0 || max < 0 {
+		return 0, 0, errors.New("affine stretch factors must be positive")
+	} else if min > max {
+		return 0, 0, errors.New("affine MinStretch exceeds MaxStretch")
+	}
+	return
+}
+
+func (a *Affine) validate() error {
+	if err := validateWarpInterpolations(a.Interpolations); err != nil {
+		return err
+	}
+	if a.MaxShear < 0 || a.MaxShear >= 1 {
+		return errors.New("affine MaxShear must be between 0 and 1")
+	}
+	_, _, err := a.stretchRange()
+	return err
+}
+
+// A Perspective manipulates images by moving each of
+// their corners inward by a random amount, producing
+// the keystone distortion seen when photographing a
+// screen or print off-axis.
+type Perspective struct {
+	// Interpolations stores the allowed interpolation
+	// techniques for sampling the source image.
+	// If this is nil, all techniques are allowed.
+	Interpolations []WarpInterpolation
+
+	// MaxCornerShift is the largest distance that a
+	// corner may move along each axis, measured as a
+	// fraction of the image's size along that axis.
+	MaxCornerShift float64
+
+	// Background is the color used for regions of the
+	// output outside of the warped image.
+	// The zero value is transparent black.
+	Background color.RGBA
+}
+
+// Manipulate applies a random four-corner homography to
+// the image.
+// The resulting image has the same dimensions as the
+// original.
+func (p *Perspective) Manipulate(img image.Image) image.Image {
+	width := float64(img.Bounds().Dx())
+	height := float64(img.Bounds().Dy())
+	corners := [][2]float64{{0, 0}, {width, 0}, {width, height}, {0, height}}
+	inwardX := []float64{1, -1, -1, 1}
+	inwardY := []float64{1, 1, -1, -1}
+
+	warped := make([][2]float64, len(corners))
+	for i, corner := range corners {
+		warped[i] = [2]float64{
+			corner[0] + inwardX[i]*rand.Float64()*p.MaxCornerShift*width,
+			corner[1] + inwardY[i]*rand.Float64()*p.MaxCornerShift*height,
+		}
+	}
+
+	inverse, ok := fitHomography(warped, corners)
+	if !ok {
+		return img
+	}
+	return warpImage(img, inverse, img.Bounds().Dx(), img.Bounds().Dy(),
+		randomWarpInterpolation(p.Interpolations), p.Background)
+}
+
+func (p *Perspective) validate() error {
+	if err := validateWarpInterpolations(p.Interpolations); err != nil {
+		return err
+	}
+	if p.MaxCornerShift < 0 || p.MaxCornerShift >= 0.5 {
+		return errors.New("perspective MaxCornerShift must be between 0 and 0.5")
+	}
+	return nil
+}
+
+func randomWarpInterpolation(interps []WarpInterpolation) WarpInterpolation {
+	if interps == nil {
+		interps = []WarpInterpolation{BilinearWarp, NearestWarp}
+	}
+	return interps[rand.Intn(len(interps))]
+}
+
+func validateWarpInterpolations(interps []WarpInterpolation) error {
+	if interps != nil && len(interps) == 0 {
+		return errors.New("warp Interpolations is empty")
+	}
+	for _, interp := range interps {
+		if interp != BilinearWarp && interp != NearestWarp {
+			return errors.New("unknown warp interpolation: " + strconv.Itoa(int(interp)))
+		}
+	}
+	return nil
+}
+
+// warpImage creates a new image by mapping each of its
+// pixels back into the source image using the inverse
+// transformation.
+// Coordinates are relative to the source image's
+// bounds, with pixel centers at half-integers.
+func warpImage(img image.Image, inverse homography, width, height int,
+	interp WarpInterpolation, background color.RGBA) image.Image {
+	bounds := img.Bounds()
+	newImage := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			srcX, srcY := inverse.Apply(float64(x)+0.5, float64(y)+0.5)
+			if srcX < 0 || srcY < 0 || srcX >= float64(bounds.Dx()) ||
+				srcY >= float64(bounds.Dy()) || math.IsNaN(srcX) || math.IsNaN(srcY) {
+				newImage.SetRGBA(x, y, background)
+				continue
+			}
+			if interp == NearestWarp {
+				newImage.Set(x, y, img.At(bounds.Min.X+int(srcX), bounds.Min.Y+int(srcY)))
+			} else {
+				newImage.Set(x, y, bilinearSample(img, srcX-0.5, srcY-0.5))
+			}
+		}
+	}
+	return newImage
+}
+
+// bilinearSample interpolates between the four pixels
+// surrounding a point, where the point is relative to
+// the image's bounds and pixel centers are integers.
+func bilinearSample(img image.Image, x, y float64) color.Color {
+	bounds := img.Bounds()
+	x0 := int(math.Floor(x))
+	y0 := int(math.Floor(y))
+	fracX := x - float64(x0)
+	fracY := y - float64(y0)
+
+	var sums [4]float64
+	for _, offset := range [][2]int{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+		weight := math.Abs(1-float64(offset[0])-fracX) * math.Abs(1-float64(offset[1])-fracY)
+		px := clampInt(x0+offset[0], 0, bounds.Dx()-1)
+		py := clampInt(y0+offset[1], 0, bounds.Dy()-1)
+		r, g, b, a := img.At(bounds.Min.X+px, bounds.Min.Y+py).RGBA()
+		for i, c := range []uint32{r, g, b, a} {
+			sums[i] += weight * float64(c)
+		}
+	}
+	return color.RGBA64{
+		R: uint16(sums[0] + 0.5),
+		G: uint16(sums[1] + 0.5),
+		B: uint16(sums[2] + 0.5),
+		A: uint16(sums[3] + 0.5),
+	}
+}
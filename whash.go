@@ -0,0 +1,223 @@
+package samepic
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	DefaultWaveletHashScaleSize = 64
+	DefaultWaveletHashLevel     = 3
+	DefaultWaveletHashThreshold = 0.85
+)
+
+// A Wavelet is a family of discrete wavelets.
+type Wavelet int
+
+const (
+	HaarWavelet Wavelet = iota
+	Daubechies4Wavelet
+)
+
+// A WaveletSubband is one of the four outputs of a level
+// of a 2D discrete wavelet transform.
+// The first letter indicates whether the low-pass (L) or
+// high-pass (H) filter was applied along the x axis, and
+// the second letter indicates the same for the y axis.
+type WaveletSubband int
+
+const (
+	WaveletLL WaveletSubband = iota
+	WaveletLH
+	WaveletHL
+	WaveletHH
+)
+
+// WaveletHash compares two images by hashing one subband
+// of a multi-level discrete wavelet transform of each
+// image.
+// Each coefficient in the subband is compared to the
+// subband's median to produce a hash bit.
+type WaveletHash struct {
+	// ScaleSize is the size to which images are scaled
+	// before the wavelet transform.
+	// It should be divisible by 2^Level.
+	//
+	// If this is 0, DefaultWaveletHashScaleSize is used.
+	ScaleSize int
+
+	// Level is the number of times the transform is
+	// applied, each time to the LL subband of the
+	// previous level.
+	// The hash has (ScaleSize/2^Level)^2 bits.
+	//
+	// If this is 0, DefaultWaveletHashLevel is used.
+	Level int
+
+	// Subband is the subband of the final level which is
+	// hashed.
+	// The default, WaveletLL, hashes a low-pass version
+	// of the image, while the other subbands hash edges.
+	Subband WaveletSubband
+
+	// Wavelet is the wavelet used for the transform.
+	// The default is HaarWavelet.
+	Wavelet Wavelet
+
+	// Threshold is the minimum fraction of hash bits that
+	// must match for two images to be considered the same.
+	//
+	// If this is 0, DefaultWaveletHashThreshold is used.
+	Threshold float64
+}
+
+// Same computes the hashes of two images and uses the
+// results to determine if the images are the same.
+func (w *WaveletHash) Same(img1, img2 image.Image) bool {
+	hash1 := w.Hash(img1)
+	hash2 := w.Hash(img2)
+	return hashMatchRatio(hash1, hash2) >= w.threshold()
+}
+
+// SameBatch finds pairs of near duplicates.
+func (w *WaveletHash) SameBatch(images <-chan *IDImage) <-chan *Pair {
+	return hashSameBatch(images, w.Hash, w.threshold())
+}
+
+// Hash creates the perceptual hash of an image.
+func (w *WaveletHash) Hash(img image.Image) []bool {
+	scaleSize := w.ScaleSize
+	if scaleSize == 0 {
+		scaleSize = DefaultWaveletHashScaleSize
+	}
+	level := w.Level
+	if level == 0 {
+		level = DefaultWaveletHashLevel
+	}
+
+	lowPass, highPass := w.filters()
+	approx := grayPixels(img, scaleSize, scaleSize)
+	size := scaleSize
+	var subbands [4][]float64
+	for i := 0; i < level && size >= 2; i++ {
+		subbands = dwt2D(approx, size, lowPass, highPass)
+		approx = subbands[WaveletLL]
+		size /= 2
+	}
+
+	coeffs := subbands[w.Subband]
+	if coeffs == nil {
+		// The image was too small to transform.
+		coeffs = approx
+	}
+	median := medianValue(coeffs)
+	res := make([]bool, len(coeffs))
+	for i, x := range coeffs {
+		res[i] = x > median
+	}
+	return res
+}
+
+func (w *WaveletHash) matchRatio(h1, h2 []bool) float64 {
+	return hashMatchRatio(h1, h2)
+}
+
+// transformHash rearranges the bits of a Haar LL hash,
+// which is a block-averaged version of the image, to get
+// the hash of a transformed image.
+// Other subbands and the asymmetric Daubechies filters
+// change in more complex ways under transforms, so nil
+// is returned for them.
+func (w *WaveletHash) transformHash(hash []bool, d Dihedral) []bool {
+	if w.Subband != WaveletLL || w.Wavelet != HaarWavelet {
+		return nil
+	}
+	return transformGridBits(hash, squareSide(len(hash)), d)
+}
+
+func (w *WaveletHash) threshold() float64 {
+	if w.Threshold == 0 {
+		return DefaultWaveletHashThreshold
+	}
+	return w.Threshold
+}
+
+// filters returns the low-pass and high-pass
+// decomposition filters for the wavelet.
+func (w *WaveletHash) filters() (lowPass, highPass []float64) {
+	switch w.Wavelet {
+	case HaarWavelet:
+		lowPass = []float64{math.Sqrt2 / 2, math.Sqrt2 / 2}
+	case Daubechies4Wavelet:
+		sqrt3 := math.Sqrt(3)
+		norm := 4 * math.Sqrt2
+		lowPass = []float64{
+			(1 + sqrt3) / norm,
+			(3 + sqrt3) / norm,
+			(3 - sqrt3) / norm,
+			(1 - sqrt3) / norm,
+		}
+	default:
+		panic("unknown wavelet")
+	}
+
+	// The high-pass filter is the alternating flip of the
+	// low-pass filter.
+	highPass = make([]float64, len(lowPass))
+	for i, x := range lowPass {
+		highPass[len(lowPass)-1-i] = x
+		if i%2 == 1 {
+			highPass[len(lowPass)-1-i] = -x
+		}
+	}
+	return
+}
+
+// dwt2D applies one level of a 2D discrete wavelet
+// transform to a square, row-major matrix, using
+// periodic boundary conditions.
+// It returns the four subbands, indexed by
+// WaveletSubband, each of which is a square matrix
+// half the size of the input.
+func dwt2D(pixels []float64, size int, lowPass, highPass []float64) [4][]float64 {
+	half := size / 2
+
+	// Filter along the x axis.
+	var xBands [2][]float64
+	for i, filter := range [][]float64{lowPass, highPass} {
+		xBands[i] = make([]float64, half*size)
+		for y := 0; y < size; y++ {
+			row := pixels[y*size : (y+1)*size]
+			for x := 0; x < half; x++ {
+				xBands[i][y*half+x] = applyFilter(row, 1, size, 2*x, filter)
+			}
+		}
+	}
+
+	// Filter each of the results along the y axis.
+	var res [4][]float64
+	for i, xBand := range xBands {
+		for j, filter := range [][]float64{lowPass, highPass} {
+			band := make([]float64, half*half)
+			for x := 0; x < half; x++ {
+				column := xBand[x:]
+				for y := 0; y < half; y++ {
+					band[y*half+x] = applyFilter(column, half, size, 2*y, filter)
+				}
+			}
+			res[i*2+j] = band
+		}
+	}
+	return res
+}
+
+// applyFilter computes the dot product of a filter with
+// a strided signal starting at the given index, wrapping
+// around the end of the signal.
+func applyFilter(signal []float64, stride, length, start int, filter []float64) float64 {
+	var sum float64
+	for k, coeff := range filter {
+		sum += coeff * signal[((start+k)%length)*stride]
+	}
+	return sum
+}